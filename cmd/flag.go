@@ -34,6 +34,8 @@ var log = vklogv2.New(nil)
 func InstallFlags(flags *pflag.FlagSet, c *provider.Opts) {
 	flags.StringVar(&c.KubeConfigPath, "kubeconfig", "", "cluster client configuration")
 	flags.StringVar(&c.KubeClusterDomain, "cluster-domain", provider.DefaultKubeClusterDomain, "cluster domain")
+	flags.IPSliceVar(&c.ClusterDNS, "cluster-dns", nil, "comma-separated list of DNS server IP addresses for Pods with the ClusterFirst DNS policy")
+	flags.StringVar(&c.ResolvConf, "resolv-conf", provider.DefaultResolvConf, "resolver configuration file used as the basis for the Pods' DNS configuration, empty means none")
 	flags.StringVar(&c.NodeName, "nodename", "", "value to be set as the Node name and label node.k8s.io/hostname")
 	flags.StringVar(&c.ListenAddress, "addr", provider.DefaultListenAddr, "address to bind for serving requests from the Kubernetes API server")
 	flags.StringVar(&c.MetricsAddr, "metrics-addr", provider.DefaultMetricsAddr, "address to listen for metrics/stats requests")
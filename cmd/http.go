@@ -104,6 +104,15 @@ func setupKubeletServer(ctx context.Context, config *provider.Opts, p provider.P
 				nodeapi.WithExecStreamIdleTimeout(config.StreamIdleTimeout),
 			),
 		).Methods("POST", "GET")
+		r.HandleFunc(
+			"/attach/{namespace}/{pod}/{container}",
+			nodeapi.HandleContainerExec(
+				p.AttachToContainer,
+				nodeapi.WithExecStreamCreationTimeout(config.StreamCreationTimeout),
+				nodeapi.WithExecStreamIdleTimeout(config.StreamIdleTimeout),
+			),
+		).Methods("POST", "GET")
+		r.HandleFunc("/portForward/{namespace}/{pod}", p.PortForwardHandler).Methods("POST", "GET")
 
 		// TODO(pires) uncomment this when VK imports k8s.io/kubelet v0.20+
 		//if p.GetStatsSummary != nil {
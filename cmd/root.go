@@ -81,13 +81,13 @@ func runRootCommand(ctx context.Context, opts *provider.Opts) error {
 	informerFactory := kubeinformers.NewSharedInformerFactoryWithOptions(client, opts.InformerResyncPeriod)
 	secretInformer := informerFactory.Core().V1().Secrets()
 	configMapInformer := informerFactory.Core().V1().ConfigMaps()
-	serviceInformer := informerFactory.Core().V1().Services() // TODO(pires) why Services?
+	serviceInformer := informerFactory.Core().V1().Services() // for the service link environment variables
 
 	// Setup the known Pods related resources manager.
 	podResourceWatcher := kubernetes.NewPodResourceWatcher(informerFactory)
 
 	// Setup the systemd provider.
-	p, err := provider.New(ctx, opts, podResourceWatcher)
+	p, err := provider.New(ctx, opts, podResourceWatcher, client.CoreV1())
 	if err != nil {
 		return err
 	}
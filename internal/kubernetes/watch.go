@@ -34,6 +34,8 @@ type PodResourceManager interface {
 	ConfigMapLister() listersv1.ConfigMapLister
 	// SecretLister lists Secret resources.
 	SecretLister() listersv1.SecretLister
+	// ServiceLister lists Service resources.
+	ServiceLister() listersv1.ServiceLister
 }
 
 // watcher checks the API server for configMap and secret updates and notifies the provider.
@@ -49,8 +51,9 @@ type watcher struct {
 	// secretKeysByPod enables reverse lookup Secrets keys per Pod.
 	secretKeysByPod map[types.NamespacedName][]types.NamespacedName
 
-	cmLister     listersv1.ConfigMapLister
-	secretLister listersv1.SecretLister
+	cmLister      listersv1.ConfigMapLister
+	secretLister  listersv1.SecretLister
+	serviceLister listersv1.ServiceLister
 }
 
 var _ PodResourceManager = (*watcher)(nil)
@@ -67,6 +70,7 @@ func newPodResourceWatcher(informerFactory informers.SharedInformerFactory) *wat
 		secretKeysByPod: make(map[types.NamespacedName][]types.NamespacedName),
 		cmLister:        informerFactory.Core().V1().ConfigMaps().Lister(),
 		secretLister:    informerFactory.Core().V1().Secrets().Lister(),
+		serviceLister:   informerFactory.Core().V1().Services().Lister(),
 	}
 }
 
@@ -80,6 +84,10 @@ func (w *watcher) SecretLister() listersv1.SecretLister {
 	return w.secretLister
 }
 
+func (w *watcher) ServiceLister() listersv1.ServiceLister {
+	return w.serviceLister
+}
+
 func (w *watcher) EventHandlerFuncs(ctx context.Context, updater ResourceUpdater) cache.ResourceEventHandlerFuncs {
 	return cache.ResourceEventHandlerFuncs{
 		AddFunc: func(obj interface{}) {
@@ -136,19 +144,64 @@ func (w *watcher) Watch(pod *corev1.Pod) {
 
 	podKey := types.NamespacedName{Namespace: pod.Namespace, Name: pod.Name}
 
+	configMaps, secrets := podReferences(pod)
+	for _, name := range configMaps {
+		cmKey := types.NamespacedName{Namespace: pod.Namespace, Name: name}
+		w.configs[cmKey] = append(w.configs[cmKey], pod.DeepCopy())
+		w.cmKeysByPod[podKey] = append(w.cmKeysByPod[podKey], cmKey)
+	}
+	for _, name := range secrets {
+		secretKey := types.NamespacedName{Namespace: pod.Namespace, Name: name}
+		w.secrets[secretKey] = append(w.secrets[secretKey], pod.DeepCopy())
+		w.secretKeysByPod[podKey] = append(w.secretKeysByPod[podKey], secretKey)
+	}
+}
+
+// podReferences returns the names of the ConfigMaps and Secrets the pod references in its volumes and in the
+// environment of its containers. Each name is returned once.
+func podReferences(pod *corev1.Pod) (configMaps, secrets []string) {
+	seenCM, seenSecret := map[string]bool{}, map[string]bool{}
+	addCM := func(name string) {
+		if !seenCM[name] {
+			seenCM[name] = true
+			configMaps = append(configMaps, name)
+		}
+	}
+	addSecret := func(name string) {
+		if !seenSecret[name] {
+			seenSecret[name] = true
+			secrets = append(secrets, name)
+		}
+	}
+
 	for _, v := range pod.Spec.Volumes {
 		switch {
 		case v.ConfigMap != nil:
-			cmKey := types.NamespacedName{Namespace: pod.Namespace, Name: v.ConfigMap.Name}
-			w.configs[cmKey] = append(w.configs[cmKey], pod.DeepCopy())
-			w.cmKeysByPod[podKey] = append(w.cmKeysByPod[podKey], cmKey)
-
+			addCM(v.ConfigMap.Name)
 		case v.Secret != nil:
-			secretKey := types.NamespacedName{Namespace: pod.Namespace, Name: v.Secret.SecretName}
-			w.secrets[secretKey] = append(w.secrets[secretKey], pod.DeepCopy())
-			w.secretKeysByPod[podKey] = append(w.secretKeysByPod[podKey], secretKey)
+			addSecret(v.Secret.SecretName)
+		}
+	}
+	for _, c := range append(pod.Spec.InitContainers, pod.Spec.Containers...) {
+		for _, from := range c.EnvFrom {
+			switch {
+			case from.ConfigMapRef != nil:
+				addCM(from.ConfigMapRef.Name)
+			case from.SecretRef != nil:
+				addSecret(from.SecretRef.Name)
+			}
+		}
+		for _, e := range c.Env {
+			switch {
+			case e.ValueFrom == nil:
+			case e.ValueFrom.ConfigMapKeyRef != nil:
+				addCM(e.ValueFrom.ConfigMapKeyRef.Name)
+			case e.ValueFrom.SecretKeyRef != nil:
+				addSecret(e.ValueFrom.SecretKeyRef.Name)
+			}
 		}
 	}
+	return configMaps, secrets
 }
 
 // UnwatchPod removes the watches for the pod.
@@ -1,8 +1,10 @@
 package kubernetes
 
 import (
+	"reflect"
 	"testing"
 
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/informers"
 )
@@ -59,3 +61,26 @@ func TestWatcher(t *testing.T) {
 		t.Fatal("expected no pods to be known to the watcher")
 	}
 }
+
+func TestPodReferences(t *testing.T) {
+	pod := &corev1.Pod{}
+	pod.Spec.Volumes = []corev1.Volume{{Name: "v", VolumeSource: corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{LocalObjectReference: corev1.LocalObjectReference{Name: "cm"}}}}}
+	pod.Spec.Containers = []corev1.Container{{
+		EnvFrom: []corev1.EnvFromSource{
+			{ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "cm"}}},
+			{SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "s1"}}},
+		},
+		Env: []corev1.EnvVar{
+			{Name: "A", Value: "a"},
+			{Name: "B", ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "s2"}, Key: "k"}}},
+		},
+	}}
+
+	configMaps, secrets := podReferences(pod)
+	if !reflect.DeepEqual(configMaps, []string{"cm"}) {
+		t.Errorf("expected configMaps [cm], got %v", configMaps)
+	}
+	if !reflect.DeepEqual(secrets, []string{"s1", "s2"}) {
+		t.Errorf("expected secrets [s1 s2], got %v", secrets)
+	}
+}
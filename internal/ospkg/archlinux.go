@@ -26,28 +26,67 @@ func (p *ArchLinuxManager) Setup() error {
 	return nil
 }
 
+// Install installs pkg from the repositories. Those only have the newest version of a package, so a requested version
+// can't be installed: it's an error if the installed version doesn't match it.
 func (p *ArchLinuxManager) Install(pkg, version string) (bool, error) {
 	log.WithField("os", "archlinux").Infof("checking if %q is installed", Clean(pkg))
 	if path.IsAbs(pkg) {
 		return false, nil
 	}
+	if v, err := p.Version(pkg); err == nil {
+		return false, checkVersion(pkg, v, version)
+	}
 
-	checkCmdArgs := []string{"-Qi", pkg}
-	checkCmd := exec.Command(pacmanCommand, checkCmdArgs...)
+	installCmdArgs := []string{"-S", "--noconfirm", pkg}
+	installCmd := exec.Command(pacmanCommand, installCmdArgs...)
+	if out, err := installCmd.CombinedOutput(); err != nil {
+		return false, fmt.Errorf("failed to install: %s\n%s", err, out)
+	}
+	v, err := p.Version(pkg)
+	if err != nil {
+		return false, err
+	}
+	return true, checkVersion(pkg, v, version)
+}
 
-	err := checkCmd.Run()
-	if err == nil {
-		// package exists
-		// TODO: check installed version
+// Upgrade upgrades pkg to the newest version in the repositories, see Install.
+func (p *ArchLinuxManager) Upgrade(pkg, version string) (bool, error) {
+	if path.IsAbs(pkg) {
 		return false, nil
 	}
+	before, _ := p.Version(pkg)
+	installCmdArgs := []string{"-Sy", "--noconfirm", "--needed", pkg}
+	installCmd := exec.Command(pacmanCommand, installCmdArgs...)
+	if out, err := installCmd.CombinedOutput(); err != nil {
+		return false, fmt.Errorf("failed to upgrade: %s\n%s", err, out)
+	}
+	after, err := p.Version(pkg)
+	if err != nil {
+		return false, err
+	}
+	return before != after, checkVersion(pkg, after, version)
+}
 
-	// no way to specify a package version in arch
-	installCmdArgs := []string{"-S", "--noconfirm", pkg}
-	installCmd := exec.Command(aptGetCommand, installCmdArgs...)
+// checkVersion returns an error if the installed version of pkg doesn't match the requested version, see
+// versionMatches.
+func checkVersion(pkg, installed, version string) error {
+	if versionMatches(installed, version) {
+		return nil
+	}
+	return fmt.Errorf("package %s is installed at version %s, version %s was requested: pacman can only install the newest version", pkg, installed, version)
+}
 
-	_, err = installCmd.CombinedOutput()
-	return true, err
+func (p *ArchLinuxManager) Version(pkg string) (string, error) {
+	out, err := exec.Command(pacmanCommand, "-Q", pkg).Output()
+	if err != nil {
+		return "", ErrNotInstalled
+	}
+	// pkg version
+	fields := strings.Fields(string(out))
+	if len(fields) != 2 {
+		return "", ErrNotInstalled
+	}
+	return fields[1], nil
 }
 
 func (p *ArchLinuxManager) Unitfile(pkg string) (string, error) {
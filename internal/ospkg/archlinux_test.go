@@ -0,0 +1,15 @@
+package ospkg
+
+import "testing"
+
+func TestCheckVersion(t *testing.T) {
+	if err := checkVersion("nginx", "1.20.1-1", "1.20.1"); err != nil {
+		t.Errorf("expected version 1.20.1 to match, got %s", err)
+	}
+	if err := checkVersion("nginx", "1.20.1-1", ""); err != nil {
+		t.Errorf("expected any version to match, got %s", err)
+	}
+	if err := checkVersion("nginx", "1.20.1-1", "1.2"); err == nil {
+		t.Errorf("expected version 1.2 not to match 1.20.1-1")
+	}
+}
@@ -21,6 +21,7 @@ var _ Manager = (*DebianManager)(nil)
 const (
 	aptGetCommand                    = "/usr/bin/apt-get"
 	dpkgCommand                      = "/usr/bin/dpkg"
+	dpkgQueryCommand                 = "/usr/bin/dpkg-query"
 	debianSystemdUnitfilesPathPrefix = "/lib/systemd/system/"
 )
 
@@ -30,17 +31,58 @@ func (p *DebianManager) Install(pkg, version string) (bool, error) {
 	if path.IsAbs(pkg) {
 		return false, nil
 	}
-	checkCmd := exec.Command(dpkgCommand, "-s", Clean(pkg))
-	if err := checkCmd.Run(); err == nil {
+	if v, err := p.Version(Clean(pkg)); err == nil && versionMatches(v, version) {
 		return false, nil
 	}
+	if err := p.install(pkg, version); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (p *DebianManager) Upgrade(pkg, version string) (bool, error) {
+	fnlog := log.WithField("os", "debian")
+	if path.IsAbs(pkg) {
+		return false, nil
+	}
+	before, _ := p.Version(Clean(pkg))
+	if !strings.HasPrefix(pkg, "https://") {
+		fnlog.Infof("running %s update", aptGetCommand)
+		if out, err := exec.Command(aptGetCommand, "-qq", "update").CombinedOutput(); err != nil {
+			return false, fmt.Errorf("failed to update: %s\n%s", err, out)
+		}
+	}
+	if err := p.install(pkg, version); err != nil {
+		return false, err
+	}
+	after, err := p.Version(Clean(pkg))
+	if err != nil {
+		return false, err
+	}
+	return before != after, nil
+}
 
+func (p *DebianManager) Version(pkg string) (string, error) {
+	out, err := exec.Command(dpkgQueryCommand, "-W", "-f=${db:Status-Status} ${Version}", pkg).Output()
+	if err != nil {
+		return "", ErrNotInstalled
+	}
+	fields := strings.Fields(string(out))
+	if len(fields) != 2 || fields[0] != "installed" {
+		return "", ErrNotInstalled
+	}
+	return fields[1], nil
+}
+
+// install installs pkg, or upgrades or downgrades it to version if it's already installed.
+func (p *DebianManager) install(pkg, version string) error {
+	fnlog := log.WithField("os", "debian")
 	installCmd := new(exec.Cmd)
 	switch {
 	case strings.HasPrefix(pkg, "https://"):
 		pkgToInstall, err := fetch(pkg, "")
 		if err != nil {
-			return false, err
+			return err
 		}
 		installCmdArgs := []string{"-i", pkgToInstall}
 		installCmd = exec.Command(dpkgCommand, installCmdArgs...)
@@ -49,13 +91,13 @@ func (p *DebianManager) Install(pkg, version string) (bool, error) {
 		if version != "" {
 			pkgToInstall = fmt.Sprintf("%s=%s*", pkg, version)
 		}
-		installCmdArgs := []string{"-qq", "--assume-yes", "--no-install-recommends", "install", pkgToInstall}
+		installCmdArgs := []string{"-qq", "--assume-yes", "--no-install-recommends", "--allow-downgrades", "install", pkgToInstall}
 		installCmd = exec.Command(aptGetCommand, installCmdArgs...)
 	}
 
 	policyfile, err := policy()
 	if err != nil {
-		return false, err
+		return err
 	}
 	defer os.Remove(policyfile)
 
@@ -66,10 +108,9 @@ func (p *DebianManager) Install(pkg, version string) (bool, error) {
 
 	fnlog.Infof("running %s", installCmd)
 	if out, err := installCmd.CombinedOutput(); err != nil {
-		return false, fmt.Errorf("failed to install: %s\n%s", err, out)
+		return fmt.Errorf("failed to install: %s\n%s", err, out)
 	}
-
-	return true, nil
+	return nil
 }
 
 // policy writes a small script to disk, that only does exit 0
@@ -27,3 +27,23 @@ func TestDebian(t *testing.T) {
 		t.Errorf("expected unit to be %s, got %s", "/lib/systemd/system/ssh.service", unit)
 	}
 }
+
+func TestVersionMatches(t *testing.T) {
+	tests := []struct {
+		installed string
+		version   string
+		exp       bool
+	}{
+		{"1.18.0-6ubuntu14", "", true},
+		{"1.18.0-6ubuntu14", "1.18.0-6ubuntu14", true},
+		{"1.18.0-6ubuntu14", "1.18.0", true},
+		{"1.18", "1.1", false},
+		{"1.18.0-6ubuntu14", "1.18.0-6", false},
+		{"1:8.4p1-5", "1:8.4p1", true},
+	}
+	for _, tc := range tests {
+		if got := versionMatches(tc.installed, tc.version); got != tc.exp {
+			t.Errorf("installed %q, version %q, expected %t, got %t", tc.installed, tc.version, tc.exp, got)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package ospkg
+
+import (
+	"path"
+	"strings"
+)
+
+// ParseImage splits the image into the package and its version: "nginx:1.18.0-6ubuntu14" returns "nginx" and
+// "1.18.0-6ubuntu14". The image is split on the first colon, as Debian versions may start with an epoch, e.g.
+// "openssh-server:1:8.4p1-5". Absolute paths and URLs are returned as-is, without a version.
+func ParseImage(image string) (pkg, version string) {
+	if path.IsAbs(image) || strings.HasPrefix(image, "http://") || strings.HasPrefix(image, "https://") {
+		return image, ""
+	}
+	i := strings.Index(image, ":")
+	if i < 0 {
+		return image, ""
+	}
+	version = image[i+1:]
+	if version == "latest" {
+		version = ""
+	}
+	return image[:i], version
+}
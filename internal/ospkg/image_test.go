@@ -0,0 +1,24 @@
+package ospkg
+
+import "testing"
+
+func TestParseImage(t *testing.T) {
+	tests := []struct {
+		image   string
+		pkg     string
+		version string
+	}{
+		{"nginx", "nginx", ""},
+		{"nginx:1.18.0-6ubuntu14", "nginx", "1.18.0-6ubuntu14"},
+		{"nginx:latest", "nginx", ""},
+		{"openssh-server:1:8.4p1-5", "openssh-server", "1:8.4p1-5"},
+		{"/usr/bin/coredns", "/usr/bin/coredns", ""},
+		{"https://www.example.org:8443/coredns_1.7.1_amd64.deb", "https://www.example.org:8443/coredns_1.7.1_amd64.deb", ""},
+	}
+	for _, tc := range tests {
+		pkg, version := ParseImage(tc.image)
+		if pkg != tc.pkg || version != tc.version {
+			t.Errorf("image %q, expected %q and %q, got %q and %q", tc.image, tc.pkg, tc.version, pkg, version)
+		}
+	}
+}
@@ -1,6 +1,9 @@
 package ospkg
 
 import (
+	"errors"
+	"strings"
+
 	vklogv2 "github.com/virtual-kubelet/virtual-kubelet/log/klogv2"
 )
 
@@ -10,9 +13,25 @@ var log = vklogv2.New(nil)
 // Manager represents OS package management.
 type Manager interface {
 	// Install install the given package at the given version, the returned boolean is true.
-	// Does nothing if package is already installed, in this case the returned boolean is false.
+	// Does nothing if package is already installed (at that version, if given), in this case the returned boolean is false.
 	Install(pkg, version string) (bool, error)
+	// Upgrade installs or upgrades the given package to the given version, or the newest one available if version is
+	// empty. The returned boolean is true if a package was installed or upgraded.
+	Upgrade(pkg, version string) (bool, error)
+	// Version returns the installed version of the given package.
+	// Returns ErrNotInstalled if the package isn't installed.
+	Version(pkg string) (string, error)
 	// Unitfile returns the location of the unitfile for the given package
 	// Returns an error if no unitfiles were found
 	Unitfile(pkg string) (string, error)
 }
+
+// ErrNotInstalled is returned by Version when a package is not installed.
+var ErrNotInstalled = errors.New("package not installed")
+
+// versionMatches returns true if the installed version satisfies the requested version, which may leave out the
+// Debian revision or the Arch Linux pkgrel: "1.18.0" matches "1.18.0-6ubuntu14", but "1.1" doesn't match "1.18". An
+// empty version matches any.
+func versionMatches(installed, version string) bool {
+	return version == "" || installed == version || strings.HasPrefix(installed, version+"-")
+}
@@ -13,6 +13,8 @@ var _ Manager = (*NoopManager)(nil)
 
 func (p *NoopManager) Setup() error                              { return nil }
 func (p *NoopManager) Install(pkg, version string) (bool, error) { return true, nil }
+func (p *NoopManager) Upgrade(pkg, version string) (bool, error) { return true, nil }
+func (p *NoopManager) Version(pkg string) (string, error)        { return "", nil }
 func (p *NoopManager) Unitfile(pkg string) (string, error) {
 	// This is fine as pod creation will synthesize a unit file.
 	return "", fmt.Errorf("noop")
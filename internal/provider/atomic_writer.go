@@ -0,0 +1,172 @@
+package provider
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// dataDirName is the symbolic link that points to the directory holding the current contents of a volume.
+	dataDirName    = "..data"
+	newDataDirName = "..data_tmp"
+)
+
+// fileProjection is a file in a volume: its contents and mode.
+type fileProjection struct {
+	data []byte
+	mode int32
+}
+
+// writeAtomic writes payload, keyed by the path relative to the volume, to the volume at dir. It uses the layout
+// of the kubelet's AtomicWriter, so that readers always see a consistent set of files:
+//
+//	<dir>/..2006_01_02_15_04_05.1234 holds the files of the payload
+//	<dir>/..data                     points to the above
+//	<dir>/<key>                      points to ..data/<key>, for each top level path of the payload
+//
+// A new timestamped directory is written on every change and ..data is swapped to it with a rename, which is atomic.
+// Paths that are no longer in the payload are then removed, as is the previous directory. If the payload didn't
+// change nothing is written.
+func writeAtomic(dir string, payload map[string]fileProjection, uid, gid string) error {
+	for path := range payload {
+		if err := validatePayloadPath(path); err != nil {
+			return err
+		}
+	}
+
+	oldTsDir, err := os.Readlink(filepath.Join(dir, dataDirName))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if oldTsDir != "" && payloadUnchanged(filepath.Join(dir, oldTsDir), payload) {
+		return nil
+	}
+
+	tsDir, err := ioutil.TempDir(dir, time.Now().UTC().Format("..2006_01_02_15_04_05."))
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(tsDir, 0755); err != nil {
+		return err
+	}
+	if err := chown(tsDir, uid, gid); err != nil {
+		return err
+	}
+	for path, f := range payload {
+		file := filepath.Join(tsDir, path)
+		if err := mkdirAllChown(filepath.Dir(file), 0755, uid, gid); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(file, f.data, os.FileMode(f.mode)); err != nil {
+			return err
+		}
+		// WriteFile is subject to the umask.
+		if err := os.Chmod(file, os.FileMode(f.mode)); err != nil {
+			return err
+		}
+		if err := chown(file, uid, gid); err != nil {
+			return err
+		}
+	}
+
+	tmpLink := filepath.Join(dir, newDataDirName)
+	os.Remove(tmpLink)
+	if err := os.Symlink(filepath.Base(tsDir), tmpLink); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpLink, filepath.Join(dir, dataDirName)); err != nil {
+		return err
+	}
+
+	// Create the links to ..data for the new top level paths and remove the ones that are gone. Regular files and
+	// directories in their way are left-overs from before the volume used this layout.
+	visible := map[string]bool{}
+	for path := range payload {
+		visible[strings.SplitN(filepath.ToSlash(path), "/", 2)[0]] = true
+	}
+	for name := range visible {
+		link := filepath.Join(dir, name)
+		fi, err := os.Lstat(link)
+		if err == nil && fi.Mode()&os.ModeSymlink != 0 {
+			continue
+		}
+		if err == nil {
+			if err := os.RemoveAll(link); err != nil {
+				return err
+			}
+		}
+		if err := os.Symlink(filepath.Join(dataDirName, name), link); err != nil {
+			return err
+		}
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), "..") || visible[e.Name()] {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(dir, e.Name())); err != nil {
+			return err
+		}
+	}
+
+	if oldTsDir != "" {
+		return os.RemoveAll(filepath.Join(dir, oldTsDir))
+	}
+	return nil
+}
+
+// validatePayloadPath checks that path is relative, doesn't step out of the volume with ".." and doesn't start
+// with "..", which would clash with the volume's own bookkeeping.
+func validatePayloadPath(path string) error {
+	if path == "" {
+		return fmt.Errorf("invalid path: must not be empty")
+	}
+	if filepath.IsAbs(path) {
+		return fmt.Errorf("invalid path %q: must be relative", path)
+	}
+	if strings.HasPrefix(path, "..") {
+		return fmt.Errorf("invalid path %q: must not start with '..'", path)
+	}
+	for _, elem := range strings.Split(filepath.ToSlash(path), "/") {
+		if elem == ".." {
+			return fmt.Errorf("invalid path %q: must not contain '..'", path)
+		}
+	}
+	return nil
+}
+
+// payloadUnchanged returns true if tsDir holds exactly the files of payload, with the same contents and modes.
+func payloadUnchanged(tsDir string, payload map[string]fileProjection) bool {
+	files := 0
+	err := filepath.Walk(tsDir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(tsDir, path)
+		if err != nil {
+			return err
+		}
+		f, ok := payload[rel]
+		if !ok || fi.Mode().Perm() != os.FileMode(f.mode).Perm() {
+			return fmt.Errorf("changed")
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil || !bytes.Equal(data, f.data) {
+			return fmt.Errorf("changed")
+		}
+		files++
+		return nil
+	})
+	return err == nil && files == len(payload)
+}
@@ -0,0 +1,69 @@
+package provider
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteAtomic(t *testing.T) {
+	dir := t.TempDir()
+	// A left-over from before the volume used the atomic writer.
+	if err := ioutil.WriteFile(filepath.Join(dir, "a"), []byte("legacy"), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	payload := map[string]fileProjection{
+		"a":     {data: []byte("1"), mode: 0644},
+		"sub/b": {data: []byte("2"), mode: 0600},
+	}
+	if err := writeAtomic(dir, payload, "", ""); err != nil {
+		t.Fatal(err)
+	}
+	first, err := os.Readlink(filepath.Join(dir, dataDirName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, f := range payload {
+		fi, err := os.Lstat(filepath.Join(dir, strings.Split(path, "/")[0]))
+		if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+			t.Errorf("expected %s to be a symbolic link, got %v", path, err)
+		}
+		buf, err := ioutil.ReadFile(filepath.Join(dir, path))
+		if err != nil || string(buf) != string(f.data) {
+			t.Errorf("expected %s to contain %q, got %q (%v)", path, f.data, buf, err)
+		}
+		if fi, err := os.Stat(filepath.Join(dir, path)); err != nil || fi.Mode().Perm() != os.FileMode(f.mode) {
+			t.Errorf("expected %s to have mode %o, got %v", path, f.mode, fi.Mode().Perm())
+		}
+	}
+
+	// Nothing changed, nothing should be written.
+	if err := writeAtomic(dir, payload, "", ""); err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := os.Readlink(filepath.Join(dir, dataDirName)); again != first {
+		t.Errorf("expected %s to still point to %s, got %s", dataDirName, first, again)
+	}
+
+	// Remove a key and change another.
+	payload = map[string]fileProjection{"a": {data: []byte("3"), mode: 0644}}
+	if err := writeAtomic(dir, payload, "", ""); err != nil {
+		t.Fatal(err)
+	}
+	if buf, _ := ioutil.ReadFile(filepath.Join(dir, "a")); string(buf) != "3" {
+		t.Errorf("expected a to contain %q, got %q", "3", buf)
+	}
+	if _, err := os.Lstat(filepath.Join(dir, "sub")); !os.IsNotExist(err) {
+		t.Errorf("expected sub to be removed, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, first)); !os.IsNotExist(err) {
+		t.Errorf("expected the previous data directory to be removed, got %v", err)
+	}
+
+	if err := writeAtomic(dir, map[string]fileProjection{"../escape": {}}, "", ""); err == nil {
+		t.Errorf("expected error for a path outside of the volume")
+	}
+}
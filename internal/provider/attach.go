@@ -0,0 +1,274 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/coreos/go-systemd/v22/sdjournal"
+	"github.com/virtual-kubelet/systemk/internal/unit"
+	nodeapi "github.com/virtual-kubelet/virtual-kubelet/node/api"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const stdinDir = "stdin"
+
+// console holds the stdio of a unit whose container has stdin and/or tty set. With a tty the unit's stdin, stdout
+// and stderr are connected to a pseudo terminal of which we hold the master. Without a tty only stdin is connected to
+// a fifo of which we hold the write end, stdout and stderr go to the journal as usual.
+type console struct {
+	tty     *os.File // pty master
+	ttyPath string   // pty slave
+	stdin   *os.File // fifo
+
+	mu   sync.Mutex
+	subs map[chan []byte]struct{}
+}
+
+// consoles tracks the consoles of all units, keyed by unit name.
+type consoles struct {
+	mu sync.RWMutex
+	m  map[string]*console
+}
+
+func newConsoles() *consoles { return &consoles{m: make(map[string]*console)} }
+
+// open creates the console for container c of pod which runs as unit name and returns uf with the unit's stdio
+// wired to it. If the container has neither stdin nor tty set, uf is returned as-is. An existing console of the same
+// kind is reused, so that regenerating the unit (see UpdatePod) yields the same unit file.
+func (cs *consoles) open(pod *corev1.Pod, c corev1.Container, name string, uf *unit.File) (*unit.File, error) {
+	if cs == nil || (!c.Stdin && !c.TTY) {
+		cs.close(name)
+		return uf, nil
+	}
+	con := cs.get(name)
+	if con == nil || (con.tty != nil) != c.TTY {
+		cs.close(name)
+		var err error
+		if con, err = newConsole(pod, c); err != nil {
+			return nil, err
+		}
+		cs.mu.Lock()
+		cs.m[name] = con
+		cs.mu.Unlock()
+	}
+
+	if con.tty != nil {
+		uf = uf.Overwrite("Service", "TTYPath", con.ttyPath)
+		uf = uf.Overwrite("Service", "StandardInput", "tty")
+		uf = uf.Overwrite("Service", "StandardOutput", "tty")
+		uf = uf.Overwrite("Service", "StandardError", "tty")
+		return uf, nil
+	}
+	return uf.Overwrite("Service", "StandardInput", "file:"+con.stdin.Name()), nil
+}
+
+func newConsole(pod *corev1.Pod, c corev1.Container) (*console, error) {
+	con := &console{subs: make(map[chan []byte]struct{})}
+	if c.TTY {
+		master, slave, err := openPty()
+		if err != nil {
+			return nil, err
+		}
+		// We only need the name of the slave, systemd opens it for the unit.
+		slave.Close()
+		con.tty = master
+		con.ttyPath = slave.Name()
+		go con.pump()
+		return con, nil
+	}
+
+	dir := filepath.Join(varrun, string(pod.UID), stdinDir)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	fifo := filepath.Join(dir, c.Name)
+	if err := syscall.Mkfifo(fifo, 0600); err != nil && !os.IsExist(err) {
+		return nil, err
+	}
+	// Opening a fifo read-write doesn't block, and because we keep it open the unit never sees EOF.
+	f, err := os.OpenFile(fifo, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	con.stdin = f
+	return con, nil
+}
+
+// close closes the console of unit name, if there is one.
+func (cs *consoles) close(name string) {
+	if cs == nil {
+		return
+	}
+	cs.mu.Lock()
+	con, ok := cs.m[name]
+	delete(cs.m, name)
+	cs.mu.Unlock()
+	if !ok {
+		return
+	}
+	if con.tty != nil {
+		con.tty.Close()
+	}
+	if con.stdin != nil {
+		con.stdin.Close()
+	}
+}
+
+func (cs *consoles) get(name string) *console {
+	if cs == nil {
+		return nil
+	}
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	return cs.m[name]
+}
+
+// pump reads the terminal's output and hands it to the attached clients. It must always run, otherwise the unit
+// blocks when the terminal's buffer is full.
+func (con *console) pump() {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := con.tty.Read(buf)
+		if n > 0 {
+			b := make([]byte, n)
+			copy(b, buf[:n])
+			con.mu.Lock()
+			for ch := range con.subs {
+				select {
+				case ch <- b:
+				default: // slow client, drop
+				}
+			}
+			con.mu.Unlock()
+		}
+		if errors.Is(err, os.ErrClosed) {
+			return
+		}
+		if err != nil {
+			// EIO: nothing has the slave open (yet), i.e. the unit isn't running.
+			time.Sleep(1 * time.Second)
+		}
+	}
+}
+
+func (con *console) subscribe() chan []byte {
+	ch := make(chan []byte, 64)
+	con.mu.Lock()
+	con.subs[ch] = struct{}{}
+	con.mu.Unlock()
+	return ch
+}
+
+func (con *console) unsubscribe(ch chan []byte) {
+	con.mu.Lock()
+	delete(con.subs, ch)
+	con.mu.Unlock()
+}
+
+// AttachToContainer attaches to the stdio of the unit running container. The unused cmd makes this a
+// nodeapi.ContainerExecHandlerFunc, which lets us reuse the exec handler for attach.
+func (p *p) AttachToContainer(ctx context.Context, namespace, name, container string, _ []string, attach nodeapi.AttachIO) error {
+	fnlog := log.
+		WithField("podNamespace", namespace).
+		WithField("podName", name).
+		WithField("containerName", container)
+
+	fnlog.Debug("AttachToContainer called")
+
+	unitName := unitPrefix(namespace, name) + separator + container + unit.ServiceSuffix
+	if _, running := p.unitRunning(unitName); !running {
+		return errNotRunning(unitName)
+	}
+
+	// The attach ends when the client detaches (closes stdin or stops reading) or when the unit stops.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		tick := time.NewTicker(2 * time.Second)
+		defer tick.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-tick.C:
+				if _, running := p.unitRunning(unitName); !running {
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	con := p.consoles.get(unitName)
+	if in := attach.Stdin(); in != nil && con != nil {
+		w := con.stdin
+		if con.tty != nil {
+			w = con.tty
+		}
+		go func() {
+			io.Copy(w, in)
+			cancel()
+		}()
+	}
+
+	out := attach.Stdout()
+	if out == nil {
+		<-ctx.Done()
+		return nil
+	}
+
+	if con != nil && con.tty != nil {
+		go func() {
+			for {
+				select {
+				case size := <-attach.Resize():
+					if err := resizePty(con.tty, size); err != nil {
+						fnlog.Warnf("failed to resize terminal: %s", err)
+					}
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+
+		ch := con.subscribe()
+		defer con.unsubscribe(ch)
+		for {
+			select {
+			case b := <-ch:
+				if _, err := out.Write(b); err != nil {
+					return nil
+				}
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	}
+
+	// No tty, stdout and stderr go to the journal, follow it from now on.
+	r, err := sdjournal.NewJournalReader(sdjournal.JournalReaderConfig{
+		Since:     -1 * time.Millisecond,
+		Matches:   []sdjournal.Match{{Field: sdjournal.SD_JOURNAL_FIELD_SYSTEMD_UNIT, Value: unitName}},
+		Formatter: journalMessage,
+	})
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	until := make(chan time.Time)
+	go func() {
+		<-ctx.Done()
+		close(until)
+	}()
+	if err := r.Follow(until, flushOnWrite(out)); err != nil && err != sdjournal.ErrExpired {
+		fnlog.Debugf("stopped following the journal: %s", err)
+	}
+	return nil
+}
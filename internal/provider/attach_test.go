@@ -0,0 +1,119 @@
+package provider
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/virtual-kubelet/systemk/internal/kubernetes"
+	"github.com/virtual-kubelet/systemk/internal/ospkg"
+	"github.com/virtual-kubelet/systemk/internal/unit"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/informers"
+)
+
+func TestConsoleTTY(t *testing.T) {
+	log = &noopLogger{}
+	cs := newConsoles()
+	pod := &corev1.Pod{}
+	pod.Namespace, pod.Name, pod.UID = "default", "tty", "aa-bb"
+	c := corev1.Container{Name: "bash", TTY: true, Stdin: true}
+	name := podToUnitName(pod, c.Name)
+
+	uf, _ := unit.NewFile(synthUnit)
+	uf, err := cs.open(pod, c, name, uf)
+	if err != nil {
+		t.Skipf("failed to open pty: %s", err)
+	}
+	defer cs.close(name)
+
+	if x := lastValue(uf, "Service", "StandardInput"); x != "tty" {
+		t.Errorf("expected StandardInput to be %q, got %q", "tty", x)
+	}
+	path := lastValue(uf, "Service", "TTYPath")
+
+	con := cs.get(name)
+	ch := con.subscribe()
+	defer con.unsubscribe(ch)
+
+	// Act as the unit and write to the terminal.
+	slave, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer slave.Close()
+	slave.Write([]byte("hello"))
+
+	select {
+	case b := <-ch:
+		if string(b) != "hello" {
+			t.Errorf("expected %q, got %q", "hello", b)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("expected output from the terminal, got none")
+	}
+}
+
+// runningUnits reports all units as active.
+type runningUnits struct{ *restartRecorder }
+
+func (r runningUnits) State(name string) (*unit.State, error) {
+	s, err := r.restartRecorder.State(name)
+	if s != nil {
+		s.ActiveState = "active"
+	}
+	return s, err
+}
+
+func TestRestoreConsoles(t *testing.T) {
+	log = &noopLogger{}
+	restarted := new(p)
+	p := new(p)
+	p.pkgManager = &ospkg.NoopManager{}
+	mock, _ := unit.NewMockManager()
+	p.unitManager = mock
+	p.consoles = newConsoles()
+	p.config = &Opts{NodeName: "localhost"}
+	p.podResourceManager = kubernetes.NewPodResourceWatcher(informers.NewSharedInformerFactory(nil, 0))
+
+	pod := &corev1.Pod{}
+	pod.Namespace, pod.Name, pod.UID = "default", "tty", "aa-bb"
+	pod.Spec.Containers = []corev1.Container{{Name: "bash", Image: "bash", TTY: true, Stdin: true}, {Name: "plain", Image: "bash"}}
+	if err := p.CreatePod(context.TODO(), pod); err != nil {
+		t.Skipf("failed to create pod with a pty: %s", err)
+	}
+	defer p.DeletePod(context.TODO(), pod)
+	name := podToUnitName(pod, "bash")
+	uf, _ := unit.NewFile(mock.Unit(name))
+	old := lastValue(uf, "Service", "TTYPath")
+
+	// After a restart of systemk the unit gets a new pty and is restarted, so it doesn't use the old one.
+	rec := &restartRecorder{Manager: mock}
+	restarted.unitManager = runningUnits{rec}
+	restarted.consoles = newConsoles()
+	defer restarted.consoles.close(name)
+	restarted.restoreConsoles(pod)
+
+	uf, _ = unit.NewFile(mock.Unit(name))
+	con := restarted.consoles.get(name)
+	if con == nil || con.tty == nil {
+		t.Fatal("expected a new console for the unit")
+	}
+	if path := lastValue(uf, "Service", "TTYPath"); path != con.ttyPath || path == old {
+		t.Errorf("expected TTYPath %s of the new pty instead of %s, got %s", con.ttyPath, old, path)
+	}
+	if len(rec.restarted) != 1 || rec.restarted[0] != name {
+		t.Errorf("expected only unit %q to be restarted, got %v", name, rec.restarted)
+	}
+
+	// Regenerating the unit reuses the new console, so UpdatePod doesn't restart it again.
+	rec.restarted = nil
+	restarted.pkgManager, restarted.config, restarted.podResourceManager = p.pkgManager, p.config, p.podResourceManager
+	if err := restarted.UpdatePod(context.TODO(), pod); err != nil {
+		t.Fatal(err)
+	}
+	if len(rec.restarted) != 0 {
+		t.Errorf("expected no units to be restarted, got %v", rec.restarted)
+	}
+}
@@ -0,0 +1,220 @@
+package provider
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/virtual-kubelet/systemk/internal/unit"
+)
+
+// cachedProperties are the service properties we need to build a Pod's status.
+var cachedProperties = []string{
+	"ExecMainStartTimestamp",
+	"ExecMainExitTimestamp",
+	"ExecMainStatus",
+	"MainPID",
+	"NRestarts",
+	"Result",
+}
+
+// unitCache caches the unit files and the state of our units and of the Pods' slices, so that GetPod, GetPods and
+// GetPodStatus don't need any D-Bus round trips. It is filled from the unit directory at startup, unit files are
+// updated when they are loaded and the states are updated when systemd signals a unit's properties changed.
+type unitCache struct {
+	m   unit.Manager
+	dir string
+
+	mu    sync.RWMutex
+	units map[string]*cachedUnit
+}
+
+type cachedUnit struct {
+	state *unit.State
+	props map[string]string
+}
+
+func newUnitCache(m unit.Manager, dir string) *unitCache {
+	return &unitCache{m: m, dir: dir, units: make(map[string]*cachedUnit)}
+}
+
+// rebuild fills the cache from the unit files in the unit directory.
+func (c *unitCache) rebuild() error {
+	names, err := c.m.Units()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		slice := strings.HasPrefix(name, prefix+"-") && strings.HasSuffix(name, unit.SliceSuffix)
+		if !strings.HasPrefix(name, prefix+separator) && !slice {
+			continue
+		}
+		buf, err := ioutil.ReadFile(filepath.Join(c.dir, name))
+		if err != nil {
+			log.Warnf("failed to read unit %q: %s", name, err)
+			continue
+		}
+		if slice && !isPodSlice(name, string(buf)) {
+			continue
+		}
+		c.load(name, string(buf))
+	}
+	return nil
+}
+
+// run keeps the cache up to date. It refreshes a unit whenever systemd signals a change and calls changed afterwards.
+// As a safety net all units are refreshed every resyncInterval.
+func (c *unitCache) run(ctx context.Context, changed func(name string)) {
+	ch := make(chan string, 256)
+	if err := c.m.Subscribe(ch); err != nil {
+		log.Errorf("failed to subscribe to systemd signals, only refreshing every %s: %s", resyncInterval, err)
+	}
+	resync := time.NewTicker(resyncInterval)
+	defer resync.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case name := <-ch:
+			if c.refresh(name) {
+				changed(name)
+			}
+		case <-resync.C:
+			c.mu.RLock()
+			names := make([]string, 0, len(c.units))
+			for name := range c.units {
+				names = append(names, name)
+			}
+			c.mu.RUnlock()
+			for _, name := range names {
+				c.refresh(name)
+			}
+		}
+	}
+}
+
+// load caches the unit file of name, and refreshes its state.
+func (c *unitCache) load(name, data string) {
+	c.mu.Lock()
+	cu, ok := c.units[name]
+	if !ok {
+		cu = &cachedUnit{state: &unit.State{}, props: map[string]string{}}
+		cu.state.Name = name
+		c.units[name] = cu
+	}
+	cu.state.UnitData = data
+	c.mu.Unlock()
+	c.refresh(name)
+}
+
+// remove removes name from the cache.
+func (c *unitCache) remove(name string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	delete(c.units, name)
+	c.mu.Unlock()
+}
+
+// refresh queries systemd for the state of name. It returns false if name isn't cached.
+func (c *unitCache) refresh(name string) bool {
+	c.mu.RLock()
+	_, ok := c.units[name]
+	c.mu.RUnlock()
+	if !ok {
+		return false
+	}
+
+	s, err := c.m.State(name)
+	if err != nil {
+		log.Debugf("failed to get state of unit %q: %s", name, err)
+		s = &unit.State{}
+	}
+	props := make(map[string]string, len(cachedProperties))
+	if !strings.HasSuffix(name, unit.SliceSuffix) { // slices don't have service properties
+		for _, prop := range cachedProperties {
+			props[prop] = c.m.ServiceProperty(name, prop)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cu, ok := c.units[name]
+	if !ok { // removed in the mean time
+		return false
+	}
+	state := &unit.State{UnitStatus: s.UnitStatus, UnitData: cu.state.UnitData}
+	state.Name = name
+	cu.state, cu.props = state, props
+	return true
+}
+
+// states returns the states of the units that have prefix.
+func (c *unitCache) states(prefix string) map[string]*unit.State {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	states := make(map[string]*unit.State)
+	for name, cu := range c.units {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		s := *cu.state
+		states[name] = &s
+	}
+	return states
+}
+
+// property returns the cached service property of name, ok is false if it isn't cached.
+func (c *unitCache) property(name, property string) (string, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	cu, ok := c.units[name]
+	if !ok {
+		return "", false
+	}
+	v, ok := cu.props[property]
+	return v, ok
+}
+
+// unitStates returns the states of the units that have prefix, from the cache if there is one.
+func (p *p) unitStates(prefix string) (map[string]*unit.State, error) {
+	if p.cache == nil {
+		return p.unitManager.States(prefix)
+	}
+	return p.cache.states(prefix), nil
+}
+
+// unitState returns the state of unit name, from the cache if there is one.
+func (p *p) unitState(name string) (*unit.State, error) {
+	if p.cache != nil {
+		if s, ok := p.cache.states(name)[name]; ok {
+			return s, nil
+		}
+	}
+	return p.unitManager.State(name)
+}
+
+// serviceProperty returns the service property of unit name, from the cache if it's cached.
+func (p *p) serviceProperty(name, property string) string {
+	if p.cache != nil {
+		if v, ok := p.cache.property(name, property); ok {
+			return v
+		}
+	}
+	return p.unitManager.ServiceProperty(name, property)
+}
+
+// loadUnit loads the unit file uf as name and caches it.
+func (p *p) loadUnit(name string, uf *unit.File) error {
+	if err := p.unitManager.Load(name, *uf); err != nil {
+		return err
+	}
+	if p.cache != nil {
+		p.cache.load(name, uf.String())
+	}
+	return nil
+}
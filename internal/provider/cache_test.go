@@ -0,0 +1,64 @@
+package provider
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/virtual-kubelet/systemk/internal/unit"
+)
+
+func TestUnitCache(t *testing.T) {
+	log = &noopLogger{}
+	dir, err := ioutil.TempDir("", "systemk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	m, _ := unit.NewMockManager()
+	uf, _ := unit.NewFile(synthUnit)
+	podSliceUnit, _ := unit.NewFile("[Slice]\nMemoryMax=1G\n[" + kubernetesSection + "]\nId=aa-bb\n")
+	parentSliceUnit, _ := unit.NewFile("[Slice]\nMemoryMax=1G\n")
+	for name, uf := range map[string]*unit.File{
+		"systemk.default.a.c.service":                        uf,
+		"systemk.default.b.c.service":                        uf,
+		"systemk-besteffort-default-a.slice":                 podSliceUnit,
+		"systemk-besteffort-default-_0123456789abcdef.slice": podSliceUnit,
+		"systemk-besteffort-default.slice":                   parentSliceUnit,
+		"systemk-besteffort.slice":                           parentSliceUnit,
+	} {
+		m.Load(name, *uf)
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(uf.String()), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c := newUnitCache(m, dir)
+	if err := c.rebuild(); err != nil {
+		t.Fatal(err)
+	}
+	states := c.states("systemk.default.a.")
+	if len(states) != 1 {
+		t.Fatalf("expected 1 unit, got %d", len(states))
+	}
+	if s := states["systemk.default.a.c.service"]; s.UnitData != uf.String() || s.Name != "systemk.default.a.c.service" {
+		t.Errorf("expected unit to be cached, got %+v", s)
+	}
+	if _, ok := c.property("systemk.default.a.c.service", "MainPID"); !ok {
+		t.Errorf("expected MainPID to be cached")
+	}
+
+	if states := c.states(prefix + "-"); len(states) != 2 {
+		t.Errorf("expected only the pods' slices to be cached, got %d slices", len(states))
+	}
+
+	c.remove("systemk.default.a.c.service")
+	if states := c.states(prefix + separator); len(states) != 1 {
+		t.Errorf("expected 1 unit after removal, got %d", len(states))
+	}
+	if c.refresh("systemk.default.a.c.service") {
+		t.Errorf("expected removed unit not to be refreshed")
+	}
+}
@@ -0,0 +1,119 @@
+package provider
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// procCgroupProcs returns the cgroup.procs files of the cgroups of the process in the proc directory, one for each
+// mounted hierarchy: the cgroup2 one and, on a legacy or hybrid setup, the cgroup v1 ones, like memory or pids.
+// Writing a pid to these moves that process into the same cgroups.
+func procCgroupProcs(proc string) ([]string, error) {
+	buf, err := ioutil.ReadFile(filepath.Join(proc, "cgroup"))
+	if err != nil {
+		return nil, err
+	}
+	mounts, err := cgroupMounts()
+	if err != nil {
+		return nil, err
+	}
+	procs := []string{}
+	for _, l := range strings.Split(string(buf), "\n") {
+		// hierarchy-ID:controller-list:cgroup-path, the controller list is empty for cgroup2.
+		fields := strings.SplitN(l, ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		for _, m := range mounts {
+			if !m.hasControllers(fields[1]) {
+				continue
+			}
+			rel, err := filepath.Rel(m.root, fields[2])
+			if err != nil || strings.HasPrefix(rel, "..") {
+				continue
+			}
+			procs = append(procs, filepath.Join(m.mountPoint, rel, "cgroup.procs"))
+			break
+		}
+	}
+	return procs, nil
+}
+
+// joinCgroups moves the process pid into the cgroups of procs, see procCgroupProcs.
+func joinCgroups(pid int, procs []string) error {
+	for _, f := range procs {
+		if err := ioutil.WriteFile(f, []byte(strconv.Itoa(pid)), 0644); err != nil {
+			return fmt.Errorf("failed to move process %d to cgroup %s: %s", pid, filepath.Dir(f), err)
+		}
+	}
+	return nil
+}
+
+// cgroupMount is a mounted cgroup hierarchy.
+type cgroupMount struct {
+	root       string          // the cgroup that is mounted
+	mountPoint string          // where it is mounted
+	options    map[string]bool // the super options, these name the controllers of a cgroup v1 hierarchy
+	v2         bool
+}
+
+// hasControllers returns true if m is the hierarchy of the controller list of /proc/<pid>/cgroup.
+func (m cgroupMount) hasControllers(controllers string) bool {
+	if controllers == "" {
+		return m.v2
+	}
+	if m.v2 {
+		return false
+	}
+	for _, c := range strings.Split(controllers, ",") {
+		if !m.options[c] {
+			return false
+		}
+	}
+	return true
+}
+
+// cgroupMounts returns the mounted cgroup hierarchies from /proc/self/mountinfo.
+func cgroupMounts() ([]cgroupMount, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	mounts := []cgroupMount{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// The optional fields end with a "-", it is followed by the filesystem type, source and super options.
+		fields := strings.Fields(scanner.Text())
+		sep := -1
+		for i := 6; i < len(fields); i++ {
+			if fields[i] == "-" {
+				sep = i
+				break
+			}
+		}
+		if sep < 0 || sep+3 >= len(fields) {
+			continue
+		}
+		fstype := fields[sep+1]
+		if fstype != "cgroup" && fstype != "cgroup2" {
+			continue
+		}
+		m := cgroupMount{
+			root:       unescapeMountInfo(fields[3]),
+			mountPoint: unescapeMountInfo(fields[4]),
+			options:    map[string]bool{},
+			v2:         fstype == "cgroup2",
+		}
+		for _, o := range strings.Split(fields[sep+3], ",") {
+			m.options[o] = true
+		}
+		mounts = append(mounts, m)
+	}
+	return mounts, scanner.Err()
+}
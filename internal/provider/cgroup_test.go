@@ -0,0 +1,33 @@
+package provider
+
+import (
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestProcCgroupProcs(t *testing.T) {
+	procs, err := procCgroupProcs("/proc/self")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(procs) == 0 {
+		t.Fatal("expected at least one cgroup")
+	}
+	pid := strconv.Itoa(os.Getpid())
+	for _, f := range procs {
+		buf, err := ioutil.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		found := false
+		for _, l := range strings.Split(string(buf), "\n") {
+			found = found || l == pid
+		}
+		if !found {
+			t.Errorf("expected process %s to be in %s", pid, f)
+		}
+	}
+}
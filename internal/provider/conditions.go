@@ -0,0 +1,121 @@
+package provider
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const conditionsFile = "conditions.json"
+
+// podConditionTypes are the conditions we report, in this order.
+var podConditionTypes = []corev1.PodConditionType{
+	podReadyToStartContainers,
+	corev1.PodInitialized,
+	corev1.PodReady,
+	corev1.ContainersReady,
+	corev1.PodScheduled,
+}
+
+// conditions tracks the conditions of the Pods, so that their transition times only change when their status does.
+// The conditions are persisted in the Pod's directory in /var/run, so they survive a restart of systemk.
+type conditions struct {
+	mu sync.Mutex
+	m  map[types.UID][]corev1.PodCondition
+}
+
+func newConditions() *conditions { return &conditions{m: make(map[types.UID][]corev1.PodCondition)} }
+
+// update returns the Pod's conditions given their current status. A condition's LastTransitionTime is set to now when
+// its status differs from the previous one. Without a conditions tracker, now is always used.
+func (cs *conditions) update(uid types.UID, status map[corev1.PodConditionType]corev1.ConditionStatus, now metav1.Time) []corev1.PodCondition {
+	if cs == nil {
+		return toConditions(nil, status, now)
+	}
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	prev, ok := cs.m[uid]
+	if !ok {
+		prev = readConditions(uid)
+	}
+	conds := toConditions(prev, status, now)
+	if !equalConditions(prev, conds) && !writeConditions(uid, conds) {
+		return conds // the Pod is deleted, don't track it again
+	}
+	cs.m[uid] = conds
+	return conds
+}
+
+// remove forgets the conditions of the Pod. The file is removed together with the Pod's directory.
+func (cs *conditions) remove(uid types.UID) {
+	if cs == nil {
+		return
+	}
+	cs.mu.Lock()
+	delete(cs.m, uid)
+	cs.mu.Unlock()
+}
+
+func toConditions(prev []corev1.PodCondition, status map[corev1.PodConditionType]corev1.ConditionStatus, now metav1.Time) []corev1.PodCondition {
+	conds := make([]corev1.PodCondition, 0, len(podConditionTypes))
+	for _, t := range podConditionTypes {
+		st, ok := status[t]
+		if !ok {
+			continue
+		}
+		c := corev1.PodCondition{Type: t, Status: st, LastTransitionTime: now}
+		for _, p := range prev {
+			if p.Type == t && p.Status == c.Status {
+				c.LastTransitionTime = p.LastTransitionTime
+			}
+		}
+		conds = append(conds, c)
+	}
+	return conds
+}
+
+func equalConditions(a, b []corev1.PodCondition) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Type != b[i].Type || a[i].Status != b[i].Status || !a[i].LastTransitionTime.Equal(&b[i].LastTransitionTime) {
+			return false
+		}
+	}
+	return true
+}
+
+func readConditions(uid types.UID) []corev1.PodCondition {
+	buf, err := ioutil.ReadFile(filepath.Join(varrun, string(uid), conditionsFile))
+	if err != nil {
+		return nil
+	}
+	var conds []corev1.PodCondition
+	if err := json.Unmarshal(buf, &conds); err != nil {
+		log.Warnf("failed to parse conditions of pod %q: %s", uid, err)
+		return nil
+	}
+	return conds
+}
+
+// writeConditions persists conds in the Pod's directory. It returns false if that doesn't exist (anymore): a GetPod
+// that races with DeletePod must not create it again after it's been cleaned up.
+func writeConditions(uid types.UID, conds []corev1.PodCondition) bool {
+	buf, _ := json.Marshal(conds)
+	err := ioutil.WriteFile(filepath.Join(varrun, string(uid), conditionsFile), buf, 0600)
+	if os.IsNotExist(err) {
+		return false
+	}
+	if err != nil {
+		log.Warnf("failed to persist conditions of pod %q: %s", uid, err)
+	}
+	return true
+}
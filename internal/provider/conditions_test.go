@@ -0,0 +1,70 @@
+package provider
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestToConditions(t *testing.T) {
+	t0 := metav1.NewTime(time.Unix(1000, 0))
+	t1 := metav1.NewTime(time.Unix(2000, 0))
+	status := map[corev1.PodConditionType]corev1.ConditionStatus{
+		corev1.PodInitialized:  corev1.ConditionTrue,
+		corev1.PodReady:        corev1.ConditionFalse,
+		corev1.ContainersReady: corev1.ConditionFalse,
+		corev1.PodScheduled:    corev1.ConditionTrue,
+	}
+	prev := toConditions(nil, status, t0)
+
+	status[corev1.PodReady] = corev1.ConditionTrue
+	status[corev1.ContainersReady] = corev1.ConditionTrue
+	conds := toConditions(prev, status, t1)
+	for _, c := range conds {
+		expect := t0
+		if c.Type == corev1.PodReady || c.Type == corev1.ContainersReady {
+			expect = t1
+		}
+		if !c.LastTransitionTime.Equal(&expect) {
+			t.Errorf("expected %s to have transitioned at %s, got %s", c.Type, expect, c.LastTransitionTime)
+		}
+	}
+	if equalConditions(prev, conds) {
+		t.Errorf("expected conditions to differ")
+	}
+}
+
+func TestConditionsPersisted(t *testing.T) {
+	log = &noopLogger{}
+	uid := types.UID("co-nd")
+	dir := filepath.Join(varrun, string(uid))
+	defer os.RemoveAll(dir)
+	status := map[corev1.PodConditionType]corev1.ConditionStatus{corev1.PodReady: corev1.ConditionTrue}
+	now := metav1.NewTime(time.Unix(1000, 0))
+
+	cs := newConditions()
+	if err := os.MkdirAll(dir, dirPerms); err != nil {
+		t.Fatal(err)
+	}
+	cs.update(uid, status, now)
+	if conds := readConditions(uid); len(conds) != 1 || !conds[0].LastTransitionTime.Equal(&now) {
+		t.Errorf("expected the conditions to be persisted, got %v", conds)
+	}
+
+	// After DeletePod cleaned up, a racing GetPod doesn't create the Pod's directory again.
+	cs.remove(uid)
+	os.RemoveAll(dir)
+	status[corev1.PodReady] = corev1.ConditionFalse
+	cs.update(uid, status, now)
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("expected the pod's directory not to be created again, got %v", err)
+	}
+	if _, ok := cs.m[uid]; ok {
+		t.Errorf("expected the deleted pod not to be tracked")
+	}
+}
@@ -0,0 +1,268 @@
+package provider
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	etcDir = "etc"
+
+	// The limits the kubelet applies to the resolver configuration, larger lists are truncated.
+	maxDNSNameservers     = 3
+	maxDNSSearchPaths     = 6
+	maxDNSSearchListChars = 256
+
+	// maxHostnameLen is the maximum length of a hostname, longer Pod names are truncated.
+	maxHostnameLen = 63
+)
+
+// etcHosts is the hosts file of the host, it's copied for Pods that use the host's network.
+var etcHosts = "/etc/hosts"
+
+// etcFiles writes the Pod's resolv.conf and hosts file under the Pod's runtime directory and returns their paths,
+// keyed by the path in /etc they should be mounted on.
+func (p *p) etcFiles(pod *corev1.Pod, uid, gid string) (map[string]string, error) {
+	resolv, err := p.podResolvConf(pod)
+	if err != nil {
+		return nil, err
+	}
+	hosts, err := p.podHosts(pod)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Join(varrun, string(pod.UID), etcDir)
+	if err := mkdirAllChown(dir, dirPerms, uid, gid); err != nil {
+		return nil, err
+	}
+	files := map[string]string{}
+	for name, data := range map[string][]byte{"resolv.conf": resolv, "hosts": hosts} {
+		if err := writeFile(dir, name, uid, gid, data); err != nil {
+			return nil, err
+		}
+		// Any user in the Pod needs to be able to read these, not just the owner.
+		if err := os.Chmod(filepath.Join(dir, name), 0644); err != nil {
+			return nil, err
+		}
+		files[filepath.Join("/etc", name)] = filepath.Join(dir, name)
+	}
+	return files, nil
+}
+
+// etcMounts returns the read-only bind mounts of files, in a deterministic order, leaving out the paths that c has a
+// volume mounted on.
+func etcMounts(files map[string]string, c corev1.Container) []string {
+	mounts := []string{}
+	for _, path := range []string{"/etc/hosts", "/etc/resolv.conf"} {
+		src, ok := files[path]
+		if !ok {
+			continue
+		}
+		mounted := false
+		for _, v := range c.VolumeMounts {
+			if filepath.Clean(v.MountPath) == path {
+				mounted = true
+			}
+		}
+		if !mounted {
+			mounts = append(mounts, src+":"+path)
+		}
+	}
+	return mounts
+}
+
+// podResolvConf returns the resolv.conf of pod, following the kubelet's rules for the Pod's DNS policy:
+//
+//   - ClusterFirst uses the cluster's DNS servers and searches the Pod's namespace, the cluster domain and the host's
+//     search domains. Pods that use the host's network, or when no cluster DNS servers are configured, fall back to
+//     Default;
+//   - ClusterFirstWithHostNet is ClusterFirst, also for Pods that use the host's network;
+//   - Default uses the host's resolver configuration;
+//   - None starts empty.
+//
+// The Pod's DNS config is then merged in.
+func (p *p) podResolvConf(pod *corev1.Pod) ([]byte, error) {
+	policy := pod.Spec.DNSPolicy
+	if policy == "" {
+		policy = corev1.DNSClusterFirst
+	}
+	if policy == corev1.DNSClusterFirst && pod.Spec.HostNetwork {
+		policy = corev1.DNSDefault
+	}
+	if (policy == corev1.DNSClusterFirst || policy == corev1.DNSClusterFirstWithHostNet) && len(p.config.ClusterDNS) == 0 {
+		log.Warnf("pod %s/%s has DNS policy %s, but no cluster DNS servers are configured, using %s", pod.Namespace, pod.Name, policy, corev1.DNSDefault)
+		policy = corev1.DNSDefault
+	}
+
+	var nameservers, searches, options []string
+	if policy != corev1.DNSNone {
+		var err error
+		nameservers, searches, options, err = parseResolvConf(p.config.ResolvConf)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if policy == corev1.DNSClusterFirst || policy == corev1.DNSClusterFirstWithHostNet {
+		domain := p.config.KubeClusterDomain
+		nameservers = []string{}
+		for _, ip := range p.config.ClusterDNS {
+			nameservers = append(nameservers, ip.String())
+		}
+		searches = append([]string{pod.Namespace + ".svc." + domain, "svc." + domain, domain}, searches...)
+		options = []string{"ndots:5"}
+	}
+
+	if c := pod.Spec.DNSConfig; c != nil {
+		nameservers = appendUnique(nameservers, c.Nameservers...)
+		searches = appendUnique(searches, c.Searches...)
+		for _, o := range c.Options {
+			opt := o.Name
+			if o.Value != nil {
+				opt += ":" + *o.Value
+			}
+			options = mergeOption(options, opt)
+		}
+	}
+
+	if len(nameservers) > maxDNSNameservers {
+		log.Warnf("pod %s/%s has more than %d nameservers, the rest is omitted", pod.Namespace, pod.Name, maxDNSNameservers)
+		nameservers = nameservers[:maxDNSNameservers]
+	}
+	searches = appendUnique(nil, searches...)
+	for len(searches) > maxDNSSearchPaths || len(strings.Join(searches, " ")) > maxDNSSearchListChars {
+		log.Warnf("pod %s/%s has more search domains than fit in resolv.conf, %q is omitted", pod.Namespace, pod.Name, searches[len(searches)-1])
+		searches = searches[:len(searches)-1]
+	}
+
+	buf := &bytes.Buffer{}
+	for _, ns := range nameservers {
+		fmt.Fprintf(buf, "nameserver %s\n", ns)
+	}
+	if len(searches) > 0 {
+		fmt.Fprintf(buf, "search %s\n", strings.Join(searches, " "))
+	}
+	if len(options) > 0 {
+		fmt.Fprintf(buf, "options %s\n", strings.Join(options, " "))
+	}
+	return buf.Bytes(), nil
+}
+
+// parseResolvConf returns the nameservers, search domains and options in the resolver configuration file path. An
+// empty path returns nothing.
+func parseResolvConf(path string) (nameservers, searches, options []string, err error) {
+	if path == "" {
+		return nil, nil, nil, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") || strings.HasPrefix(fields[0], ";") {
+			continue
+		}
+		switch fields[0] {
+		case "nameserver":
+			nameservers = append(nameservers, fields[1])
+		case "domain", "search":
+			// The last of these wins.
+			searches = fields[1:]
+		case "options":
+			for _, o := range fields[1:] {
+				options = mergeOption(options, o)
+			}
+		}
+	}
+	return nameservers, searches, options, scanner.Err()
+}
+
+// mergeOption adds the resolver option opt to options, replacing an option with the same name.
+func mergeOption(options []string, opt string) []string {
+	name := strings.SplitN(opt, ":", 2)[0]
+	for i, o := range options {
+		if strings.SplitN(o, ":", 2)[0] == name {
+			options[i] = opt
+			return options
+		}
+	}
+	return append(options, opt)
+}
+
+// appendUnique appends the elements of add to s that aren't in it yet.
+func appendUnique(s []string, add ...string) []string {
+	for _, a := range add {
+		found := false
+		for _, x := range s {
+			if x == a {
+				found = true
+				break
+			}
+		}
+		if !found {
+			s = append(s, a)
+		}
+	}
+	return s
+}
+
+// podHosts returns the hosts file of pod. Like the kubelet does, Pods that use the host's network get the host's
+// hosts file, other Pods get one with the loopback addresses and their own hostname, with a fully qualified name if
+// the Pod has a subdomain. The Pod's host aliases are appended to either.
+func (p *p) podHosts(pod *corev1.Pod) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if pod.Spec.HostNetwork {
+		data, err := ioutil.ReadFile(etcHosts)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(data)
+	} else {
+		buf.WriteString("# Kubernetes-managed hosts file.\n")
+		buf.WriteString("127.0.0.1\tlocalhost\n")
+		buf.WriteString("::1\tlocalhost ip6-localhost ip6-loopback\n")
+		buf.WriteString("fe00::0\tip6-localnet\n")
+		buf.WriteString("fe00::0\tip6-mcastprefix\n")
+		buf.WriteString("fe00::1\tip6-allnodes\n")
+		buf.WriteString("fe00::2\tip6-allrouters\n")
+		if ip := p.config.NodeInternalIP; ip != nil && !ip.IsUnspecified() {
+			hostname := podHostname(pod)
+			if pod.Spec.Subdomain != "" {
+				fqdn := fmt.Sprintf("%s.%s.%s.svc.%s", hostname, pod.Spec.Subdomain, pod.Namespace, p.config.KubeClusterDomain)
+				fmt.Fprintf(buf, "%s\t%s\t%s\n", ip, fqdn, hostname)
+			} else {
+				fmt.Fprintf(buf, "%s\t%s\n", ip, hostname)
+			}
+		}
+	}
+	if len(pod.Spec.HostAliases) > 0 {
+		buf.WriteString("\n# Entries added by HostAliases.\n")
+		for _, a := range pod.Spec.HostAliases {
+			fmt.Fprintf(buf, "%s\t%s\n", a.IP, strings.Join(a.Hostnames, "\t"))
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// podHostname returns the hostname of pod: its spec's hostname or its name, truncated to a valid length.
+func podHostname(pod *corev1.Pod) string {
+	hostname := pod.Spec.Hostname
+	if hostname == "" {
+		hostname = pod.Name
+	}
+	if len(hostname) > maxHostnameLen {
+		hostname = strings.TrimRight(hostname[:maxHostnameLen], "-.")
+	}
+	return hostname
+}
@@ -0,0 +1,123 @@
+package provider
+
+import (
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestPodResolvConf(t *testing.T) {
+	log = &noopLogger{}
+	resolv := filepath.Join(t.TempDir(), "resolv.conf")
+	host := "# comment\nnameserver 10.0.0.1\nsearch example.org\noptions ndots:2 edns0\n"
+	if err := ioutil.WriteFile(resolv, []byte(host), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ndots := "3"
+
+	tests := []struct {
+		policy      corev1.DNSPolicy
+		hostNetwork bool
+		clusterDNS  []net.IP
+		config      *corev1.PodDNSConfig
+		exp         string
+	}{
+		{
+			corev1.DNSClusterFirst, false, []net.IP{{10, 96, 0, 10}}, nil,
+			"nameserver 10.96.0.10\nsearch ns.svc.cluster.local svc.cluster.local cluster.local example.org\noptions ndots:5\n",
+		},
+		{
+			// Without cluster DNS servers ClusterFirst falls back to Default.
+			corev1.DNSClusterFirst, false, nil, nil,
+			"nameserver 10.0.0.1\nsearch example.org\noptions ndots:2 edns0\n",
+		},
+		{
+			corev1.DNSClusterFirst, true, []net.IP{{10, 96, 0, 10}}, nil,
+			"nameserver 10.0.0.1\nsearch example.org\noptions ndots:2 edns0\n",
+		},
+		{
+			corev1.DNSClusterFirstWithHostNet, true, []net.IP{{10, 96, 0, 10}}, nil,
+			"nameserver 10.96.0.10\nsearch ns.svc.cluster.local svc.cluster.local cluster.local example.org\noptions ndots:5\n",
+		},
+		{
+			corev1.DNSDefault, false, []net.IP{{10, 96, 0, 10}},
+			&corev1.PodDNSConfig{
+				Nameservers: []string{"10.0.0.1", "10.0.0.2"},
+				Searches:    []string{"example.com"},
+				Options:     []corev1.PodDNSConfigOption{{Name: "ndots", Value: &ndots}, {Name: "rotate"}},
+			},
+			"nameserver 10.0.0.1\nnameserver 10.0.0.2\nsearch example.org example.com\noptions ndots:3 edns0 rotate\n",
+		},
+		{
+			corev1.DNSNone, false, []net.IP{{10, 96, 0, 10}},
+			&corev1.PodDNSConfig{Nameservers: []string{"1.1.1.1"}},
+			"nameserver 1.1.1.1\n",
+		},
+		{
+			corev1.DNSNone, false, nil,
+			&corev1.PodDNSConfig{Searches: []string{"a", "b", "c", "d", "e", "f", "g"}},
+			"search a b c d e f\n",
+		},
+	}
+
+	for i, tc := range tests {
+		p := new(p)
+		p.config = &Opts{KubeClusterDomain: "cluster.local", ClusterDNS: tc.clusterDNS, ResolvConf: resolv}
+		pod := &corev1.Pod{}
+		pod.Namespace, pod.Name = "ns", "dns"
+		pod.Spec.DNSPolicy = tc.policy
+		pod.Spec.HostNetwork = tc.hostNetwork
+		pod.Spec.DNSConfig = tc.config
+		got, err := p.podResolvConf(pod)
+		if err != nil {
+			t.Fatalf("test %d, unexpected error: %s", i, err)
+		}
+		if string(got) != tc.exp {
+			t.Errorf("test %d, expected %q, got %q", i, tc.exp, got)
+		}
+	}
+}
+
+func TestPodHosts(t *testing.T) {
+	p := new(p)
+	p.config = &Opts{KubeClusterDomain: "cluster.local", NodeInternalIP: []byte{192, 168, 1, 1}}
+	pod := &corev1.Pod{}
+	pod.Namespace, pod.Name = "ns", "web-0"
+	pod.Spec.Hostname = "web"
+	pod.Spec.Subdomain = "nginx"
+	pod.Spec.HostAliases = []corev1.HostAlias{{IP: "10.1.2.3", Hostnames: []string{"foo.local", "bar.local"}}}
+
+	got, err := p.podHosts(pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"127.0.0.1\tlocalhost\n",
+		"192.168.1.1\tweb.nginx.ns.svc.cluster.local\tweb\n",
+		"10.1.2.3\tfoo.local\tbar.local\n",
+	} {
+		if !strings.Contains(string(got), line) {
+			t.Errorf("expected hosts file to contain %q, got %q", line, got)
+		}
+	}
+
+	pod.Spec.Hostname, pod.Spec.Subdomain = "", ""
+	pod.Name = strings.Repeat("a", maxHostnameLen-1) + "-b"
+	got, _ = p.podHosts(pod)
+	if line := "192.168.1.1\t" + strings.Repeat("a", maxHostnameLen-1) + "\n"; !strings.Contains(string(got), line) {
+		t.Errorf("expected hosts file to contain %q, got %q", line, got)
+	}
+}
+
+func TestEtcMounts(t *testing.T) {
+	files := map[string]string{"/etc/hosts": "/var/run/x/etc/hosts", "/etc/resolv.conf": "/var/run/x/etc/resolv.conf"}
+	c := corev1.Container{VolumeMounts: []corev1.VolumeMount{{Name: "h", MountPath: "/etc/hosts"}}}
+	got := etcMounts(files, c)
+	if len(got) != 1 || got[0] != "/var/run/x/etc/resolv.conf:/etc/resolv.conf" {
+		t.Errorf("expected only resolv.conf to be mounted, got %v", got)
+	}
+}
@@ -0,0 +1,211 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	emptyDirLimitsFile = "emptydir-limits.json"
+	evictedFile        = "evicted"
+
+	// evictedReason is the reason the kubelet gives for a Pod that it evicted.
+	evictedReason = "Evicted"
+
+	// emptyDirMonitorInterval is how often the usage of the emptyDirs with a sizeLimit is checked, this is the
+	// kubelet's default eviction monitoring period.
+	emptyDirMonitorInterval = 10 * time.Second
+)
+
+// emptyDirLimits are the disk backed emptyDirs of a Pod that have a sizeLimit. They're persisted in the Pod's
+// directory in /var/run, so the monitoring survives a restart of systemk; the Pod's name and QoS class are needed to
+// evict it.
+type emptyDirLimits struct {
+	Namespace string             `json:"namespace"`
+	Name      string             `json:"name"`
+	QOSClass  corev1.PodQOSClass `json:"qosClass"`
+	Volumes   []emptyDirLimit    `json:"volumes"`
+}
+
+type emptyDirLimit struct {
+	Name      string            `json:"name"`
+	Path      string            `json:"path"`
+	SizeLimit resource.Quantity `json:"sizeLimit"`
+}
+
+// mountTmpfs mounts a tmpfs of size bytes on dir, owned by uid and gid, unless something is mounted there
+// already. A size of 0 leaves the size to the kernel's default of half the memory.
+func mountTmpfs(dir string, size int64, uid, gid string) error {
+	mounted, err := isMountPoint(dir)
+	if err != nil || mounted {
+		return err
+	}
+	opts := "mode=" + strconv.FormatInt(dirPerms, 8)
+	if uid != "" {
+		opts += ",uid=" + uid
+	}
+	if gid != "" {
+		opts += ",gid=" + gid
+	}
+	if size > 0 {
+		opts += ",size=" + strconv.FormatInt(size, 10)
+	}
+	return unix.Mount("tmpfs", dir, "tmpfs", unix.MS_NOSUID|unix.MS_NODEV, opts)
+}
+
+// tmpfsSize returns the size of the tmpfs of a memory backed emptyDir: the smallest of its sizeLimit, the Pod's
+// memory limit and the node's memory, as the kubelet does. 0 means none of those is known.
+func (p *p) tmpfsSize(pod *corev1.Pod, ed *corev1.EmptyDirVolumeSource) int64 {
+	size := p.memoryCapacity
+	limits := []int64{}
+	if q, ok := podResources(pod).Limits[corev1.ResourceMemory]; ok {
+		limits = append(limits, q.Value())
+	}
+	if ed.SizeLimit != nil {
+		limits = append(limits, ed.SizeLimit.Value())
+	}
+	for _, l := range limits {
+		if l > 0 && (size <= 0 || l < size) {
+			size = l
+		}
+	}
+	return size
+}
+
+// isMountPoint returns true if something is mounted on dir, i.e. it's on another device than its parent.
+func isMountPoint(dir string) (bool, error) {
+	st, err := os.Stat(dir)
+	if err != nil {
+		return false, err
+	}
+	parent, err := os.Stat(filepath.Dir(dir))
+	if err != nil {
+		return false, err
+	}
+	return st.Sys().(*syscall.Stat_t).Dev != parent.Sys().(*syscall.Stat_t).Dev, nil
+}
+
+// unmountEmptyDirs unmounts the tmpfs of the memory backed emptyDirs of the Pod, so its directory can be removed.
+func unmountEmptyDirs(podId string) {
+	dirs, _ := filepath.Glob(filepath.Join(varrun, podId, emptyDir, "*"))
+	for _, dir := range dirs {
+		if mounted, _ := isMountPoint(dir); !mounted {
+			continue
+		}
+		if err := unix.Unmount(dir, 0); err != nil {
+			log.Warnf("failed to unmount %q: %s", dir, err)
+		}
+	}
+}
+
+// writeEmptyDirLimits persists the limits of the Pod, or removes them when there are none.
+func writeEmptyDirLimits(pod *corev1.Pod, volumes []emptyDirLimit) error {
+	path := filepath.Join(varrun, string(pod.UID), emptyDirLimitsFile)
+	if len(volumes) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	buf, err := json.Marshal(emptyDirLimits{Namespace: pod.Namespace, Name: pod.Name, QOSClass: podQOS(pod), Volumes: volumes})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), dirPerms); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, buf, 0600)
+}
+
+// monitorEmptyDirs checks the usage of the emptyDirs with a sizeLimit every emptyDirMonitorInterval.
+func (p *p) monitorEmptyDirs(ctx context.Context) {
+	ticker := time.NewTicker(emptyDirMonitorInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.checkEmptyDirs()
+		}
+	}
+}
+
+// checkEmptyDirs evicts the Pods that use more disk space in an emptyDir than its sizeLimit allows.
+func (p *p) checkEmptyDirs() {
+	files, _ := filepath.Glob(filepath.Join(varrun, "*", emptyDirLimitsFile))
+	for _, file := range files {
+		uid := types.UID(filepath.Base(filepath.Dir(file)))
+		if _, evicted := readEviction(uid); evicted {
+			continue
+		}
+		buf, err := ioutil.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		limits := emptyDirLimits{}
+		if err := json.Unmarshal(buf, &limits); err != nil {
+			log.Warnf("failed to parse emptyDir limits of pod %q: %s", uid, err)
+			continue
+		}
+		for _, v := range limits.Volumes {
+			usage, err := dirUsage(v.Path)
+			if err != nil {
+				log.Warnf("failed to get the usage of emptyDir %q of pod %s/%s: %s", v.Name, limits.Namespace, limits.Name, err)
+				continue
+			}
+			if usage > v.SizeLimit.Value() {
+				p.evict(uid, limits, fmt.Sprintf("Usage of EmptyDir volume %q exceeds the limit %q. ", v.Name, v.SizeLimit.String()))
+				break
+			}
+		}
+	}
+}
+
+// dirUsage returns the disk space used by the files and directories in dir, like du(1) does.
+func dirUsage(dir string) (int64, error) {
+	var usage int64
+	err := filepath.Walk(dir, func(_ string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+			usage += st.Blocks * 512
+		}
+		return nil
+	})
+	return usage, err
+}
+
+// evict stops all units of the Pod and records why, so the Pod's status becomes Failed with the reason Evicted.
+func (p *p) evict(uid types.UID, limits emptyDirLimits, message string) {
+	log.Warnf("evicting pod %s/%s: %s", limits.Namespace, limits.Name, message)
+	if err := ioutil.WriteFile(filepath.Join(varrun, string(uid), evictedFile), []byte(message), 0600); err != nil {
+		log.Warnf("failed to record eviction of pod %s/%s: %s", limits.Namespace, limits.Name, err)
+	}
+	slice := podSlice(limits.QOSClass, limits.Namespace, limits.Name)
+	if err := p.unitManager.TriggerStop(slice); err != nil {
+		log.Errorf("failed to trigger stop for slice %q: %s", slice, err)
+	}
+}
+
+// readEviction returns the message of the eviction of the Pod, if it was evicted.
+func readEviction(uid types.UID) (string, bool) {
+	buf, err := ioutil.ReadFile(filepath.Join(varrun, string(uid), evictedFile))
+	if err != nil {
+		return "", false
+	}
+	return string(buf), true
+}
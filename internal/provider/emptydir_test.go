@@ -0,0 +1,92 @@
+package provider
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/virtual-kubelet/systemk/internal/kubernetes"
+	"github.com/virtual-kubelet/systemk/internal/ospkg"
+	"github.com/virtual-kubelet/systemk/internal/unit"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/client-go/informers"
+)
+
+func TestTmpfsSize(t *testing.T) {
+	quantity := func(s string) *resource.Quantity { q := resource.MustParse(s); return &q }
+	tests := []struct {
+		capacity  int64
+		limit     string
+		sizeLimit *resource.Quantity
+		exp       int64
+	}{
+		{0, "", nil, 0},
+		{1 << 30, "", nil, 1 << 30},
+		{1 << 30, "", quantity("64Mi"), 64 << 20},
+		{1 << 30, "128Mi", nil, 128 << 20},
+		{1 << 30, "128Mi", quantity("256Mi"), 128 << 20},
+		{0, "", quantity("2Gi"), 2 << 30},
+	}
+	for i, tc := range tests {
+		p := new(p)
+		p.memoryCapacity = tc.capacity
+		pod := &corev1.Pod{}
+		pod.Spec.Containers = []corev1.Container{{Name: "a"}}
+		if tc.limit != "" {
+			pod.Spec.Containers[0].Resources.Limits = corev1.ResourceList{corev1.ResourceMemory: resource.MustParse(tc.limit)}
+		}
+		if got := p.tmpfsSize(pod, &corev1.EmptyDirVolumeSource{Medium: corev1.StorageMediumMemory, SizeLimit: tc.sizeLimit}); got != tc.exp {
+			t.Errorf("test %d, expected %d, got %d", i, tc.exp, got)
+		}
+	}
+}
+
+func TestDirUsage(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "data"), make([]byte, 64<<10), 0600); err != nil {
+		t.Fatal(err)
+	}
+	usage, err := dirUsage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage < 64<<10 {
+		t.Errorf("expected a usage of at least %d, got %d", 64<<10, usage)
+	}
+}
+
+func TestEmptyDirEviction(t *testing.T) {
+	log = &noopLogger{}
+	p := new(p)
+	p.pkgManager = &ospkg.NoopManager{}
+	p.unitManager, _ = unit.NewMockManager()
+	p.config = &Opts{NodeName: "localhost"}
+	p.podResourceManager = kubernetes.NewPodResourceWatcher(informers.NewSharedInformerFactory(nil, 0))
+
+	limit := resource.MustParse("16Ki")
+	pod := &corev1.Pod{}
+	pod.Namespace, pod.Name, pod.UID = "default", "evict", "ev-ict"
+	pod.Spec.Volumes = []corev1.Volume{{Name: "scratch", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{SizeLimit: &limit}}}}
+	pod.Spec.Containers = []corev1.Container{{Name: "a", Image: "bash", VolumeMounts: []corev1.VolumeMount{{Name: "scratch", MountPath: "/scratch"}}}}
+	if err := p.CreatePod(context.TODO(), pod); err != nil {
+		t.Fatal(err)
+	}
+	defer p.DeletePod(context.TODO(), pod)
+
+	p.checkEmptyDirs()
+	if status, _ := p.GetPodStatus(context.TODO(), pod.Namespace, pod.Name); status == nil || status.Reason == evictedReason {
+		t.Fatalf("expected pod not to be evicted, got %v", status)
+	}
+
+	dir := filepath.Join(varrun, string(pod.UID), emptyDir, "#0")
+	if err := ioutil.WriteFile(filepath.Join(dir, "data"), make([]byte, 64<<10), 0600); err != nil {
+		t.Fatal(err)
+	}
+	p.checkEmptyDirs()
+	status, _ := p.GetPodStatus(context.TODO(), pod.Namespace, pod.Name)
+	if status == nil || status.Phase != corev1.PodFailed || status.Reason != evictedReason {
+		t.Errorf("expected pod to be evicted, got %v", status)
+	}
+}
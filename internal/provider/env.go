@@ -1,9 +1,24 @@
 package provider
 
 import (
+	"bytes"
 	"fmt"
+	"io/ioutil"
+	"math"
 	"net"
 	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
 )
 
 // defaultEnvironment returns a list of strings formatted as VAR=VALUE
@@ -34,7 +49,403 @@ func (p *p) defaultEnvironment() []string {
 	return env
 }
 
+const (
+	// envDir holds the environment files of the Pod's containers, see writeEnvironmentFile.
+	envDir = "env"
+
+	// createContainerConfigError is the reason given for a container whose environment can't be resolved, e.g.
+	// because it refers to a ConfigMap that doesn't exist.
+	createContainerConfigError = "CreateContainerConfigError"
+)
+
+// writeEnvironmentFile writes env, the resolved environment of container c, to a file under the Pod's runtime
+// directory and returns its path, for the unit's EnvironmentFile. The environment may hold the values of Secrets,
+// which is why it's kept out of the world readable unit file: the file is only readable by root, systemd reads it
+// before it drops privileges.
+func writeEnvironmentFile(pod *corev1.Pod, c corev1.Container, env []corev1.EnvVar) (string, error) {
+	dir := filepath.Join(varrun, string(pod.UID), envDir)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	buf := &bytes.Buffer{}
+	for _, e := range env {
+		fmt.Fprintf(buf, "%s=%s\n", e.Name, quoteEnvValue(e.Value))
+	}
+
+	tmpfile, err := ioutil.TempFile(dir, "systemk.*.tmp")
+	if err != nil {
+		return "", err
+	}
+	tmpfile.Close()
+	if err := ioutil.WriteFile(tmpfile.Name(), buf.Bytes(), 0600); err != nil {
+		os.Remove(tmpfile.Name())
+		return "", err
+	}
+	path := filepath.Join(dir, c.Name)
+	return path, os.Rename(tmpfile.Name(), path)
+}
+
+// quoteEnvValue double quotes value for an environment file, escaping the characters systemd unescapes in there.
+// Newlines are kept as-is, they're allowed in a quoted value.
+func quoteEnvValue(value string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "`", "\\`", `$`, `\$`)
+	return `"` + r.Replace(value) + `"`
+}
+
 func mkEnvVar(name, value string) string {
 	const s = "SYSTEMK_"
 	return s + name + "=" + value
 }
+
+// containerEnvironment resolves the environment of container c of pod the way the kubelet does: first the keys of
+// envFrom's ConfigMaps and Secrets, then env, in which valueFrom is resolved and $(VAR) references to previously
+// defined variables are expanded, and finally the service link variables (see serviceEnvironment) that aren't
+// defined yet. A missing ConfigMap, Secret or key is an error, unless it's optional.
+func (p *p) containerEnvironment(pod *corev1.Pod, c corev1.Container) ([]corev1.EnvVar, error) {
+	services := p.serviceEnvironment(pod)
+	env := []corev1.EnvVar{}
+	index := map[string]int{}
+	set := func(name, value string) {
+		if i, ok := index[name]; ok {
+			env[i].Value = value
+			return
+		}
+		index[name] = len(env)
+		env = append(env, corev1.EnvVar{Name: name, Value: value})
+	}
+
+	for _, from := range c.EnvFrom {
+		data := map[string]string{}
+		switch {
+		case from.ConfigMapRef != nil:
+			cm, err := p.podResourceManager.ConfigMapLister().ConfigMaps(pod.Namespace).Get(from.ConfigMapRef.Name)
+			if err != nil {
+				if errors.IsNotFound(err) && isOptional(from.ConfigMapRef.Optional) {
+					continue
+				}
+				return nil, fmt.Errorf("configMap %s: %s", from.ConfigMapRef.Name, err)
+			}
+			data = cm.Data
+		case from.SecretRef != nil:
+			secret, err := p.podResourceManager.SecretLister().Secrets(pod.Namespace).Get(from.SecretRef.Name)
+			if err != nil {
+				if errors.IsNotFound(err) && isOptional(from.SecretRef.Optional) {
+					continue
+				}
+				return nil, fmt.Errorf("secret %s: %s", from.SecretRef.Name, err)
+			}
+			for k, v := range secret.Data {
+				data[k] = string(v)
+			}
+		}
+		keys := make([]string, 0, len(data))
+		for k := range data {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			name := from.Prefix + k
+			if errs := validation.IsEnvVarName(name); len(errs) > 0 {
+				log.Warnf("skipping invalid environment variable name %q of pod %s/%s: %s", name, pod.Namespace, pod.Name, strings.Join(errs, ", "))
+				continue
+			}
+			set(name, data[k])
+		}
+	}
+
+	for _, e := range c.Env {
+		if e.ValueFrom == nil {
+			values := map[string]string{}
+			for _, v := range append(services, env...) {
+				values[v.Name] = v.Value
+			}
+			set(e.Name, expandEnv(e.Value, values))
+			continue
+		}
+		value, ok, err := p.envValueFrom(pod, c, e.ValueFrom)
+		if err != nil {
+			return nil, fmt.Errorf("environment variable %s: %s", e.Name, err)
+		}
+		if ok {
+			set(e.Name, value)
+		}
+	}
+
+	for _, e := range services {
+		if _, ok := index[e.Name]; !ok {
+			set(e.Name, e.Value)
+		}
+	}
+	return env, nil
+}
+
+// serviceEnvironment returns the docker link style variables of the Services in the Pod's namespace, as the kubelet
+// generates them, unless the Pod disables enableServiceLinks. The kubernetes Service in the default namespace is
+// left out, as defaultEnvironment points its variables at the API server systemk uses.
+func (p *p) serviceEnvironment(pod *corev1.Pod) []corev1.EnvVar {
+	if p.podResourceManager == nil || (pod.Spec.EnableServiceLinks != nil && !*pod.Spec.EnableServiceLinks) {
+		return nil
+	}
+	services, err := p.podResourceManager.ServiceLister().Services(pod.Namespace).List(labels.Everything())
+	if err != nil {
+		log.Warnf("failed to list services in namespace %s: %s", pod.Namespace, err)
+		return nil
+	}
+	sort.Slice(services, func(i, j int) bool { return services[i].Name < services[j].Name })
+
+	env := []corev1.EnvVar{}
+	for _, svc := range services {
+		if svc.Spec.ClusterIP == "" || svc.Spec.ClusterIP == corev1.ClusterIPNone || len(svc.Spec.Ports) == 0 {
+			continue
+		}
+		if svc.Namespace == metav1.NamespaceDefault && svc.Name == "kubernetes" {
+			continue
+		}
+		env = append(env, serviceEnvVars(svc)...)
+	}
+	return env
+}
+
+// serviceEnvVars returns the variables of svc: <SVC>_SERVICE_HOST, <SVC>_SERVICE_PORT and <SVC>_SERVICE_PORT_<NAME>
+// for each named port, and the docker link variables <SVC>_PORT and <SVC>_PORT_<PORT>_<PROTO>{,_PROTO,_PORT,_ADDR}.
+func serviceEnvVars(svc *corev1.Service) []corev1.EnvVar {
+	ip := svc.Spec.ClusterIP
+	name := envVarName(svc.Name)
+	env := []corev1.EnvVar{
+		{Name: name + "_SERVICE_HOST", Value: ip},
+		{Name: name + "_SERVICE_PORT", Value: strconv.Itoa(int(svc.Spec.Ports[0].Port))},
+	}
+	for _, sp := range svc.Spec.Ports {
+		if sp.Name != "" {
+			env = append(env, corev1.EnvVar{Name: name + "_SERVICE_PORT_" + envVarName(sp.Name), Value: strconv.Itoa(int(sp.Port))})
+		}
+	}
+	for i, sp := range svc.Spec.Ports {
+		protocol := string(sp.Protocol)
+		if protocol == "" {
+			protocol = string(corev1.ProtocolTCP)
+		}
+		port := strconv.Itoa(int(sp.Port))
+		url := strings.ToLower(protocol) + "://" + net.JoinHostPort(ip, port)
+		if i == 0 {
+			env = append(env, corev1.EnvVar{Name: name + "_PORT", Value: url})
+		}
+		prefix := name + "_PORT_" + port + "_" + strings.ToUpper(protocol)
+		env = append(env,
+			corev1.EnvVar{Name: prefix, Value: url},
+			corev1.EnvVar{Name: prefix + "_PROTO", Value: strings.ToLower(protocol)},
+			corev1.EnvVar{Name: prefix + "_PORT", Value: port},
+			corev1.EnvVar{Name: prefix + "_ADDR", Value: ip},
+		)
+	}
+	return env
+}
+
+// envVarName returns name, upper cased and with dashes replaced by underscores.
+func envVarName(name string) string {
+	return strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// envValueFrom returns the value of source. If the referenced ConfigMap, Secret or key is optional and missing, false
+// is returned.
+func (p *p) envValueFrom(pod *corev1.Pod, c corev1.Container, source *corev1.EnvVarSource) (string, bool, error) {
+	switch {
+	case source.FieldRef != nil:
+		value, err := p.fieldPathValue(pod, source.FieldRef.FieldPath)
+		return value, err == nil, err
+	case source.ResourceFieldRef != nil:
+		value, err := containerResourceValue(pod, c, source.ResourceFieldRef)
+		return value, err == nil, err
+	case source.ConfigMapKeyRef != nil:
+		ref := source.ConfigMapKeyRef
+		cm, err := p.podResourceManager.ConfigMapLister().ConfigMaps(pod.Namespace).Get(ref.Name)
+		if err != nil {
+			if errors.IsNotFound(err) && isOptional(ref.Optional) {
+				return "", false, nil
+			}
+			return "", false, fmt.Errorf("configMap %s: %s", ref.Name, err)
+		}
+		value, ok := cm.Data[ref.Key]
+		if !ok {
+			if isOptional(ref.Optional) {
+				return "", false, nil
+			}
+			return "", false, fmt.Errorf("key %s not found in configMap %s", ref.Key, ref.Name)
+		}
+		return value, true, nil
+	case source.SecretKeyRef != nil:
+		ref := source.SecretKeyRef
+		secret, err := p.podResourceManager.SecretLister().Secrets(pod.Namespace).Get(ref.Name)
+		if err != nil {
+			if errors.IsNotFound(err) && isOptional(ref.Optional) {
+				return "", false, nil
+			}
+			return "", false, fmt.Errorf("secret %s: %s", ref.Name, err)
+		}
+		value, ok := secret.Data[ref.Key]
+		if !ok {
+			if isOptional(ref.Optional) {
+				return "", false, nil
+			}
+			return "", false, fmt.Errorf("key %s not found in secret %s", ref.Key, ref.Name)
+		}
+		return string(value), true, nil
+	}
+	return "", false, fmt.Errorf("unsupported valueFrom")
+}
+
+func isOptional(optional *bool) bool { return optional != nil && *optional }
+
+// fieldPathValue returns the value of the field of pod selected by path, as supported by the downward API.
+func (p *p) fieldPathValue(pod *corev1.Pod, path string) (string, error) {
+	if name, key, ok := subscript(path); ok {
+		switch name {
+		case "metadata.labels":
+			return pod.Labels[key], nil
+		case "metadata.annotations":
+			return pod.Annotations[key], nil
+		}
+		return "", fmt.Errorf("unsupported fieldPath %q", path)
+	}
+
+	switch path {
+	case "metadata.name":
+		return pod.Name, nil
+	case "metadata.namespace":
+		return pod.Namespace, nil
+	case "metadata.uid":
+		return string(pod.UID), nil
+	case "metadata.labels":
+		return formatMap(pod.Labels), nil
+	case "metadata.annotations":
+		return formatMap(pod.Annotations), nil
+	case "spec.nodeName":
+		return p.config.NodeName, nil
+	case "spec.serviceAccountName":
+		return pod.Spec.ServiceAccountName, nil
+	case "status.hostIP", "status.podIP", "status.podIPs":
+		// Pods share the node's network.
+		return p.config.NodeInternalIP.String(), nil
+	}
+	return "", fmt.Errorf("unsupported fieldPath %q", path)
+}
+
+// subscript splits a fieldPath of the form name['key'] in name and key.
+func subscript(path string) (name, key string, ok bool) {
+	i := strings.Index(path, "['")
+	if i < 0 || !strings.HasSuffix(path, "']") {
+		return "", "", false
+	}
+	return path[:i], path[i+2 : len(path)-2], true
+}
+
+// formatMap formats m as key="value" lines, sorted by key, like the downward API does for all labels or annotations.
+func formatMap(m map[string]string) string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	b := &strings.Builder{}
+	for _, k := range keys {
+		fmt.Fprintf(b, "%s=%q\n", k, m[k])
+	}
+	return b.String()
+}
+
+// containerResourceValue returns the value of the resource selected by fs of container c, or of the container named
+// in fs, of pod. The value is divided by the divisor and rounded up. Limits that aren't set default to the node's
+// capacity.
+func containerResourceValue(pod *corev1.Pod, c corev1.Container, fs *corev1.ResourceFieldSelector) (string, error) {
+	if fs.ContainerName != "" && fs.ContainerName != c.Name {
+		found := false
+		for _, other := range append(pod.Spec.InitContainers, pod.Spec.Containers...) {
+			if other.Name == fs.ContainerName {
+				c, found = other, true
+				break
+			}
+		}
+		if !found {
+			return "", fmt.Errorf("container %s not found", fs.ContainerName)
+		}
+	}
+
+	i := strings.Index(fs.Resource, ".")
+	if i < 0 {
+		return "", fmt.Errorf("unsupported resource %q", fs.Resource)
+	}
+	kind, name := fs.Resource[:i], corev1.ResourceName(fs.Resource[i+1:])
+	var q resource.Quantity
+	switch kind {
+	case "limits":
+		var ok bool
+		if q, ok = c.Resources.Limits[name]; !ok {
+			q = capacity()[name]
+		}
+	case "requests":
+		q = c.Resources.Requests[name]
+	default:
+		return "", fmt.Errorf("unsupported resource %q", fs.Resource)
+	}
+
+	divisor := fs.Divisor
+	if divisor.IsZero() {
+		divisor = resource.MustParse("1")
+	}
+	switch name {
+	case corev1.ResourceCPU:
+		return strconv.FormatInt(int64(math.Ceil(float64(q.MilliValue())/float64(divisor.MilliValue()))), 10), nil
+	case corev1.ResourceMemory, corev1.ResourceEphemeralStorage:
+		return strconv.FormatInt(int64(math.Ceil(float64(q.Value())/float64(divisor.Value()))), 10), nil
+	}
+	return "", fmt.Errorf("unsupported resource %q", fs.Resource)
+}
+
+// expandEnv expands the $(VAR) references in s with the values in env. References to undefined variables are left
+// as-is and $$ escapes a $.
+func expandEnv(s string, env map[string]string) string {
+	b := &strings.Builder{}
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		switch s[i+1] {
+		case '$':
+			b.WriteByte('$')
+			i++
+		case '(':
+			end := strings.IndexByte(s[i+2:], ')')
+			if end < 0 {
+				b.WriteByte(s[i])
+				continue
+			}
+			name := s[i+2 : i+2+end]
+			if v, ok := env[name]; ok {
+				b.WriteString(v)
+			} else {
+				b.WriteString(s[i : i+3+end])
+			}
+			i += 2 + end
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+// expandCommand expands the $(VAR) references in command or args, see expandEnv.
+func expandCommand(command []string, env []corev1.EnvVar) []string {
+	if command == nil {
+		return nil
+	}
+	values := map[string]string{}
+	for _, e := range env {
+		values[e.Name] = e.Value
+	}
+	expanded := make([]string, len(command))
+	for i, s := range command {
+		expanded[i] = expandEnv(s, values)
+	}
+	return expanded
+}
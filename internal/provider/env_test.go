@@ -1,7 +1,20 @@
 package provider
 
 import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
+
+	"github.com/virtual-kubelet/systemk/internal/kubernetes"
+	"github.com/virtual-kubelet/systemk/internal/ospkg"
+	"github.com/virtual-kubelet/systemk/internal/unit"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
 )
 
 func TestProviderIPEnvironment(t *testing.T) {
@@ -24,3 +37,207 @@ func TestProviderIPEnvironment(t *testing.T) {
 		t.Errorf("failed to find SYSTEMK_NODE_INTERNAL_IP or SYSTEMK_NODE_EXTERNAL_IP")
 	}
 }
+
+func TestWriteEnvironmentFile(t *testing.T) {
+	pod := &corev1.Pod{}
+	pod.UID = "env-file"
+	defer os.RemoveAll(filepath.Join(varrun, string(pod.UID)))
+
+	env := []corev1.EnvVar{{Name: "A", Value: "a b"}, {Name: "SECRET", Value: "p\"a$s`s\\\nword%"}}
+	path, err := writeEnvironmentFile(pod, corev1.Container{Name: "c"}, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("expected mode 0600, got %s", fi.Mode())
+	}
+	buf, _ := ioutil.ReadFile(path)
+	exp := "A=\"a b\"\nSECRET=\"p\\\"a\\$s\\`s\\\\\nword%\"\n"
+	if string(buf) != exp {
+		t.Errorf("expected %q, got %q", exp, buf)
+	}
+}
+
+func TestContainerEnvironment(t *testing.T) {
+	factory := informers.NewSharedInformerFactory(nil, 0)
+	factory.Core().V1().ConfigMaps().Informer().GetIndexer().Add(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "cm"},
+		Data:       map[string]string{"A": "from-cm", "B": "b", "invalid name": "x"},
+	})
+	factory.Core().V1().Secrets().Informer().GetIndexer().Add(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "secret"},
+		Data:       map[string][]byte{"password": []byte("hunter2")},
+	})
+
+	p := new(p)
+	log = &noopLogger{}
+	p.config = &Opts{NodeName: "node", NodeInternalIP: []byte{192, 168, 1, 1}}
+	p.podResourceManager = kubernetes.NewPodResourceWatcher(factory)
+
+	optional := true
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "env", Labels: map[string]string{"app": "env"}}}
+	c := corev1.Container{
+		Name: "c",
+		Resources: corev1.ResourceRequirements{
+			Limits: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("128Mi")},
+		},
+		EnvFrom: []corev1.EnvFromSource{
+			{ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "cm"}}},
+			{Prefix: "X_", ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "missing"}, Optional: &optional}},
+		},
+		Env: []corev1.EnvVar{
+			{Name: "A", Value: "overridden"},
+			{Name: "C", Value: "$(A)-$(B)-$(UNDEFINED)-$$(A)"},
+			{Name: "PASSWORD", ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "secret"}, Key: "password"}}},
+			{Name: "OPTIONAL", ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "secret"}, Key: "missing", Optional: &optional}}},
+			{Name: "POD", ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.name"}}},
+			{Name: "APP", ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.labels['app']"}}},
+			{Name: "IP", ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "status.podIP"}}},
+			{Name: "MEM", ValueFrom: &corev1.EnvVarSource{ResourceFieldRef: &corev1.ResourceFieldSelector{Resource: "limits.memory", Divisor: resource.MustParse("1Mi")}}},
+		},
+	}
+	pod.Spec.Containers = []corev1.Container{c}
+
+	env, err := p.containerEnvironment(pod, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []corev1.EnvVar{
+		{Name: "A", Value: "overridden"},
+		{Name: "B", Value: "b"},
+		{Name: "C", Value: "overridden-b-$(UNDEFINED)-$(A)"},
+		{Name: "PASSWORD", Value: "hunter2"},
+		{Name: "POD", Value: "env"},
+		{Name: "APP", Value: "env"},
+		{Name: "IP", Value: "192.168.1.1"},
+		{Name: "MEM", Value: "128"},
+	}
+	if !reflect.DeepEqual(env, expect) {
+		t.Errorf("expected environment %v, got %v", expect, env)
+	}
+
+	// A required key that is missing is an error.
+	c.Env = []corev1.EnvVar{{Name: "X", ValueFrom: &corev1.EnvVarSource{ConfigMapKeyRef: &corev1.ConfigMapKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "cm"}, Key: "missing"}}}}
+	if _, err := p.containerEnvironment(pod, c); err == nil {
+		t.Errorf("expected an error for a missing key")
+	}
+}
+
+func TestServiceEnvironment(t *testing.T) {
+	factory := informers.NewSharedInformerFactory(nil, 0)
+	services := factory.Core().V1().Services().Informer().GetIndexer()
+	services.Add(&corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "redis-master"},
+		Spec: corev1.ServiceSpec{ClusterIP: "10.0.0.11", Ports: []corev1.ServicePort{
+			{Name: "redis", Port: 6379, Protocol: corev1.ProtocolTCP},
+		}},
+	})
+	services.Add(&corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "headless"},
+		Spec:       corev1.ServiceSpec{ClusterIP: corev1.ClusterIPNone, Ports: []corev1.ServicePort{{Port: 80}}},
+	})
+	services.Add(&corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "other", Name: "other"},
+		Spec:       corev1.ServiceSpec{ClusterIP: "10.0.0.12", Ports: []corev1.ServicePort{{Port: 80}}},
+	})
+
+	p := new(p)
+	log = &noopLogger{}
+	p.podResourceManager = kubernetes.NewPodResourceWatcher(factory)
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "links"}}
+	c := corev1.Container{Name: "c", Env: []corev1.EnvVar{
+		{Name: "REDIS_MASTER_SERVICE_PORT", Value: "1234"},
+		{Name: "REDIS", Value: "$(REDIS_MASTER_SERVICE_HOST)"},
+	}}
+
+	env, err := p.containerEnvironment(pod, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []corev1.EnvVar{
+		{Name: "REDIS_MASTER_SERVICE_PORT", Value: "1234"},
+		{Name: "REDIS", Value: "10.0.0.11"},
+		{Name: "REDIS_MASTER_SERVICE_HOST", Value: "10.0.0.11"},
+		{Name: "REDIS_MASTER_SERVICE_PORT_REDIS", Value: "6379"},
+		{Name: "REDIS_MASTER_PORT", Value: "tcp://10.0.0.11:6379"},
+		{Name: "REDIS_MASTER_PORT_6379_TCP", Value: "tcp://10.0.0.11:6379"},
+		{Name: "REDIS_MASTER_PORT_6379_TCP_PROTO", Value: "tcp"},
+		{Name: "REDIS_MASTER_PORT_6379_TCP_PORT", Value: "6379"},
+		{Name: "REDIS_MASTER_PORT_6379_TCP_ADDR", Value: "10.0.0.11"},
+	}
+	if !reflect.DeepEqual(env, expect) {
+		t.Errorf("expected environment %v, got %v", expect, env)
+	}
+
+	disabled := false
+	pod.Spec.EnableServiceLinks = &disabled
+	if env := p.serviceEnvironment(pod); len(env) != 0 {
+		t.Errorf("expected no service links, got %v", env)
+	}
+}
+
+func TestContainerEnvironmentWaiting(t *testing.T) {
+	log = &noopLogger{}
+	factory := informers.NewSharedInformerFactory(nil, 0)
+	p := new(p)
+	p.pkgManager = &ospkg.NoopManager{}
+	mock, _ := unit.NewMockManager()
+	rec := &restartRecorder{Manager: mock}
+	p.unitManager = rec
+	p.config = &Opts{NodeName: "localhost"}
+	p.podResourceManager = kubernetes.NewPodResourceWatcher(factory)
+
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "env", UID: "en-v"}}
+	pod.Spec.Containers = []corev1.Container{
+		{Name: "a", Image: "bash"},
+		{Name: "b", Image: "bash", EnvFrom: []corev1.EnvFromSource{
+			{ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "cm"}}},
+		}},
+	}
+	// A missing ConfigMap only keeps its container waiting.
+	if err := p.CreatePod(context.TODO(), pod); err != nil {
+		t.Fatal(err)
+	}
+	defer p.DeletePod(context.TODO(), pod)
+	if len(rec.started) != 1 || rec.started[0] != podToUnitName(pod, "a") {
+		t.Errorf("expected only the unit of container a to be started, got %v", rec.started)
+	}
+	status, err := p.GetPodStatus(context.TODO(), pod.Namespace, pod.Name)
+	if err != nil || status == nil {
+		t.Fatalf("expected pod status, got %v", err)
+	}
+	for _, cs := range status.ContainerStatuses {
+		if cs.Name != "b" {
+			continue
+		}
+		if w := cs.State.Waiting; w == nil || w.Reason != createContainerConfigError {
+			t.Errorf("expected container b to be waiting with reason %s, got %v", createContainerConfigError, cs.State)
+		}
+	}
+
+	// Once the ConfigMap exists the unit is set up and started.
+	rec.started = nil
+	p.checkWaiting(context.TODO())
+	if len(rec.started)+len(rec.restarted) != 0 {
+		t.Errorf("expected no units to be (re)started while the configMap is missing, got %v and %v", rec.started, rec.restarted)
+	}
+	factory.Core().V1().ConfigMaps().Informer().GetIndexer().Add(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "cm"},
+		Data:       map[string]string{"A": "a"},
+	})
+	p.checkWaiting(context.TODO())
+	if len(rec.restarted) != 1 || rec.restarted[0] != podToUnitName(pod, "b") {
+		t.Errorf("expected only the unit of container b to be started, got %v", rec.restarted)
+	}
+	uf, _ := unit.NewFile(mock.Unit(podToUnitName(pod, "b")))
+	if w := unitWaiting(uf); w != nil {
+		t.Errorf("expected container b not to be waiting, got %v", w)
+	}
+	if buf, err := ioutil.ReadFile(lastValue(uf, "Service", "EnvironmentFile")); err != nil || string(buf) != "A=\"a\"\n" {
+		t.Errorf("expected the environment of container b to be written, got %q (%v)", buf, err)
+	}
+}
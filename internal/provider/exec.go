@@ -0,0 +1,225 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	nodeapi "github.com/virtual-kubelet/virtual-kubelet/node/api"
+	utilexec "k8s.io/utils/exec"
+)
+
+// unitCmd is a command that runs in the execution context of a unit, see unitCommand. Start moves the process into
+// the cgroups of the unit, so it counts against the unit's and the Pod's resource limits and it is killed with the
+// unit. Only use Start, Run and CombinedOutput, exec.Cmd's other methods that start the command don't do this.
+type unitCmd struct {
+	*exec.Cmd
+	procs []string // the cgroup.procs files of the unit's cgroups
+}
+
+// Start starts the command and moves it into the unit's cgroups. If that fails the command is killed.
+func (c *unitCmd) Start() error {
+	if err := c.Cmd.Start(); err != nil {
+		return err
+	}
+	// nsenter doesn't fork, so this is the process of command, it's moved before it gets to run much.
+	if err := joinCgroups(c.Process.Pid, c.procs); err != nil {
+		c.Process.Kill()
+		c.Wait()
+		return err
+	}
+	return nil
+}
+
+// Run starts the command and waits for it to finish.
+func (c *unitCmd) Run() error {
+	if err := c.Start(); err != nil {
+		return err
+	}
+	return c.Wait()
+}
+
+// CombinedOutput runs the command and returns its combined standard output and standard error.
+func (c *unitCmd) CombinedOutput() ([]byte, error) {
+	b := &bytes.Buffer{}
+	c.Stdout, c.Stderr = b, b
+	err := c.Run()
+	return b.Bytes(), err
+}
+
+// unitCommand returns a command that runs in the execution context of the (running) unit name. It enters the mount
+// namespace of the unit's main process, so it sees the same BindPaths, TemporaryFileSystem and ReadOnlyPaths and it
+// runs with the same user, group, environment and working directory, in the same cgroups.
+func (p *p) unitCommand(ctx context.Context, name string, command []string) (*unitCmd, error) {
+	if len(command) == 0 {
+		return nil, fmt.Errorf("no command given for unit %q", name)
+	}
+	pid := p.unitManager.ServiceProperty(name, "MainPID")
+	if pid == "" || pid == "0" {
+		return nil, errNotRunning(name)
+	}
+	proc := filepath.Join("/proc", pid)
+	uid, gid, err := procUidGid(proc)
+	if err != nil {
+		return nil, err
+	}
+	env, err := procEnviron(proc)
+	if err != nil {
+		return nil, err
+	}
+	procs, err := procCgroupProcs(proc)
+	if err != nil {
+		return nil, err
+	}
+	nsenter, err := exec.LookPath("nsenter")
+	if err != nil {
+		return nil, err
+	}
+
+	// --root and --wd without arguments use the root and working directory of the target process.
+	args := []string{"--target", pid, "--mount", "--root", "--wd", "--setuid", uid, "--setgid", gid, "--"}
+	cmd := exec.CommandContext(ctx, nsenter, append(args, command...)...)
+	cmd.Env = env
+	return &unitCmd{Cmd: cmd, procs: procs}, nil
+}
+
+// execInUnit runs command in the execution context of the unit name and streams stdin, stdout and stderr from and to
+// attach. If a TTY is requested the command runs on a pseudo terminal that follows attach's terminal resizes.
+func (p *p) execInUnit(ctx context.Context, name string, command []string, attach nodeapi.AttachIO) error {
+	cmd, err := p.unitCommand(ctx, name, command)
+	if err != nil {
+		return err
+	}
+	if attach.TTY() {
+		return runWithPty(ctx, cmd, attach)
+	}
+
+	if out := attach.Stdout(); out != nil {
+		cmd.Stdout = out
+	}
+	if errout := attach.Stderr(); errout != nil {
+		cmd.Stderr = errout
+	}
+	if in := attach.Stdin(); in != nil {
+		// Not setting cmd.Stdin directly, as Wait would then block until in is closed by the client.
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			return err
+		}
+		go func() {
+			io.Copy(stdin, in)
+			stdin.Close()
+		}()
+	}
+	return exitError(cmd.Run())
+}
+
+// runWithPty runs cmd with a pseudo terminal as its controlling terminal.
+func runWithPty(ctx context.Context, cmd *unitCmd, attach nodeapi.AttachIO) error {
+	master, slave, err := openPty()
+	if err != nil {
+		return err
+	}
+	defer master.Close()
+
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = slave, slave, slave
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true}
+	err = cmd.Start()
+	slave.Close() // the child has its own copy now
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		for {
+			select {
+			case size := <-attach.Resize():
+				if err := resizePty(master, size); err != nil {
+					log.Warnf("failed to resize terminal: %s", err)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	if in := attach.Stdin(); in != nil {
+		go io.Copy(master, in)
+	}
+	done := make(chan struct{})
+	go func() {
+		var out io.Writer = ioutil.Discard
+		if attach.Stdout() != nil {
+			out = attach.Stdout()
+		}
+		io.Copy(out, master) // returns with EIO when all copies of the slave are closed
+		close(done)
+	}()
+
+	err = cmd.Wait()
+	// Give the remaining output a chance to be copied, background processes may hold on to the slave though.
+	select {
+	case <-done:
+	case <-time.After(1 * time.Second):
+	}
+	return exitError(err)
+}
+
+// exitError converts an *exec.ExitError into an error that carries the exit code back to the client.
+func exitError(err error) error {
+	if ee, ok := err.(*exec.ExitError); ok {
+		return utilexec.CodeExitError{Err: err, Code: ee.ExitCode()}
+	}
+	return err
+}
+
+func errNotRunning(name string) error { return fmt.Errorf("unit %q is not running", name) }
+
+// procUidGid returns the effective uid and gid of the process in the proc directory.
+func procUidGid(proc string) (uid, gid string, err error) {
+	buf, err := ioutil.ReadFile(filepath.Join(proc, "status"))
+	if err != nil {
+		return "", "", err
+	}
+	for _, l := range strings.Split(string(buf), "\n") {
+		fields := strings.Fields(l)
+		if len(fields) < 3 {
+			continue
+		}
+		// Uid: real, effective, saved set, and filesystem UIDs.
+		switch fields[0] {
+		case "Uid:":
+			uid = fields[2]
+		case "Gid:":
+			gid = fields[2]
+		}
+	}
+	if uid == "" || gid == "" {
+		return "", "", fmt.Errorf("failed to find uid and gid in %s", proc)
+	}
+	return uid, gid, nil
+}
+
+// procEnviron returns the environment of the process in the proc directory.
+func procEnviron(proc string) ([]string, error) {
+	buf, err := ioutil.ReadFile(filepath.Join(proc, "environ"))
+	if err != nil {
+		return nil, err
+	}
+	env := []string{}
+	for _, e := range bytes.Split(buf, []byte{0}) {
+		if len(e) == 0 {
+			continue
+		}
+		env = append(env, string(e))
+	}
+	return env, nil
+}
@@ -0,0 +1,42 @@
+package provider
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"testing"
+
+	utilexec "k8s.io/utils/exec"
+)
+
+func TestProcUidGidEnviron(t *testing.T) {
+	uid, gid, err := procUidGid("/proc/self")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uid != strconv.Itoa(os.Geteuid()) {
+		t.Errorf("expected uid to be %d, got %s", os.Geteuid(), uid)
+	}
+	if gid != strconv.Itoa(os.Getegid()) {
+		t.Errorf("expected gid to be %d, got %s", os.Getegid(), gid)
+	}
+
+	env, err := procEnviron("/proc/self")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(env) != len(os.Environ()) {
+		t.Errorf("expected %d environment variables, got %d", len(os.Environ()), len(env))
+	}
+}
+
+func TestExitError(t *testing.T) {
+	err := exitError(exec.Command("/bin/sh", "-c", "exit 3").Run())
+	ee, ok := err.(utilexec.ExitError)
+	if !ok {
+		t.Fatalf("expected an ExitError, got %T", err)
+	}
+	if ee.ExitStatus() != 3 {
+		t.Errorf("expected exit status 3, got %d", ee.ExitStatus())
+	}
+}
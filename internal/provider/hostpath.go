@@ -0,0 +1,284 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/virtual-kubelet/systemk/internal/unit"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// createContainerError is the reason given for a container that can't be started because its unit couldn't be
+	// set up, e.g. because a hostPath volume isn't of the requested type.
+	createContainerError = "CreateContainerError"
+
+	// waitingRetryInterval is how often the units that couldn't be set up are tried again, see retryWaiting.
+	waitingRetryInterval = 10 * time.Second
+)
+
+// checkHostPath checks that the path of the hostPath volume hp is of the requested type. The *OrCreate types create
+// a missing path, owned by uid and gid, with the permissions the kubelet uses.
+func checkHostPath(hp *corev1.HostPathVolumeSource, uid, gid string) error {
+	typ := corev1.HostPathUnset
+	if hp.Type != nil {
+		typ = *hp.Type
+	}
+	path := hp.Path
+
+	switch typ {
+	case corev1.HostPathDirectoryOrCreate:
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			if err := mkdirAllChown(path, 0755, uid, gid); err != nil {
+				return fmt.Errorf("failed to create directory %s: %s", path, err)
+			}
+		}
+	case corev1.HostPathFileOrCreate:
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return fmt.Errorf("failed to create file %s: %s", path, err)
+			}
+			f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0644)
+			if err != nil {
+				return fmt.Errorf("failed to create file %s: %s", path, err)
+			}
+			f.Close()
+			if err := chown(path, uid, gid); err != nil {
+				return fmt.Errorf("failed to create file %s: %s", path, err)
+			}
+		}
+	}
+
+	if typ == corev1.HostPathUnset {
+		return nil
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("hostPath type check failed: %s does not exist", path)
+	}
+	mode := fi.Mode()
+	var (
+		ok   bool
+		what string
+	)
+	switch typ {
+	case corev1.HostPathDirectoryOrCreate, corev1.HostPathDirectory:
+		ok, what = mode.IsDir(), "directory"
+	case corev1.HostPathFileOrCreate, corev1.HostPathFile:
+		ok, what = mode.IsRegular(), "file"
+	case corev1.HostPathSocket:
+		ok, what = mode&os.ModeSocket != 0, "socket"
+	case corev1.HostPathCharDev:
+		ok, what = mode&os.ModeCharDevice != 0, "character device"
+	case corev1.HostPathBlockDev:
+		ok, what = mode&os.ModeDevice != 0 && mode&os.ModeCharDevice == 0, "block device"
+	default:
+		return fmt.Errorf("hostPath type check failed: unknown type %s", typ)
+	}
+	if !ok {
+		return fmt.Errorf("hostPath type check failed: %s is not a %s", path, what)
+	}
+	return nil
+}
+
+// unitWaiting returns the reason and message recorded in the unit when it couldn't be set up, see podUnits. Such a
+// unit is not started.
+func unitWaiting(uf *unit.File) *corev1.ContainerStateWaiting {
+	reason := lastValue(uf, kubernetesSection, "WaitingReason")
+	if reason == "" {
+		return nil
+	}
+	return &corev1.ContainerStateWaiting{Reason: reason, Message: lastValue(uf, kubernetesSection, "WaitingMessage")}
+}
+
+// retryWaiting tries to start the units that are waiting for their hostPath volumes or their environment again every
+// waitingRetryInterval, as the kubelet does on every sync: the path or the ConfigMap may have been created in the
+// mean time.
+func (p *p) retryWaiting(ctx context.Context) {
+	ticker := time.NewTicker(waitingRetryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.checkWaiting(ctx)
+		}
+	}
+}
+
+// checkWaiting calls retryWaitingUnit for the units that are waiting.
+func (p *p) checkWaiting(ctx context.Context) {
+	states, err := p.unitStates(prefix + separator)
+	if err != nil {
+		return
+	}
+	for name, s := range states {
+		uf, err := unit.NewFile(s.UnitData)
+		if err != nil {
+			continue
+		}
+		if unitWaiting(uf) == nil {
+			continue
+		}
+		p.retryWaitingUnit(ctx, types.UID(lastValue(uf, kubernetesSection, "Id")), name)
+	}
+}
+
+// retryWaitingUnit starts the waiting unit name of the Pod with uid once it can be set up. A unit waiting for its
+// hostPath volumes is complete apart from its waiting reason (see podUnits), so only their check is done again and
+// the reason removed. A unit waiting for its environment is regenerated, together with the rest of the Pod, once the
+// environment resolves. An init container's unit is started by starting the app containers, as in CreatePod. This
+// holds the Pod's lock and does nothing if the Pod was deleted or the unit regenerated in the mean time.
+func (p *p) retryWaitingUnit(ctx context.Context, uid types.UID, name string) {
+	defer p.locks.lock(uid)()
+
+	s, err := p.unitState(name)
+	if err != nil || s == nil || s.UnitData == "" {
+		return
+	}
+	uf, err := unit.NewFile(s.UnitData)
+	if err != nil || lastValue(uf, kubernetesSection, "Id") != string(uid) {
+		return
+	}
+	w := unitWaiting(uf)
+	if w == nil {
+		return
+	}
+	pod, err := readPod(uid)
+	if err != nil {
+		return
+	}
+	fnlog := log.
+		WithField("podNamespace", pod.Namespace).
+		WithField("podName", pod.Name)
+
+	unitsToStart := []string{name}
+	switch w.Reason {
+	case createContainerConfigError:
+		if err := p.checkEnvironment(pod, Container(name)); err != nil {
+			fnlog.Debugf("unit %q is still waiting: %s", name, err)
+			return
+		}
+		if err := p.updatePod(ctx, pod); err != nil {
+			fnlog.Errorf("failed to set up unit %q: %s", name, err)
+			return
+		}
+		unitsToStart = nil // started by updatePod
+
+	default:
+		if err := p.setupHostPaths(pod, Container(name)); err != nil {
+			fnlog.Debugf("unit %q is still waiting: %s", name, err)
+			return
+		}
+		uf, err = unit.NewFile(uf.Delete(kubernetesSection, "WaitingReason").Delete(kubernetesSection, "WaitingMessage").String())
+		if err != nil {
+			return
+		}
+		fnlog.Infof("reloading unit %q", name)
+		if err := p.loadUnit(name, uf); err != nil {
+			fnlog.Errorf("failed to load unit %q: %s", name, err)
+			return
+		}
+		if err := p.unitManager.Reload(); err != nil {
+			fnlog.Errorf("failed to reload systemd: %s", err)
+		}
+	}
+
+	for _, c := range pod.Spec.InitContainers {
+		if c.Name != Container(name) {
+			continue
+		}
+		if unitsToStart, err = p.appUnitsToStart(pod); err != nil {
+			fnlog.Debugf("not starting the app containers: %s", err)
+			return
+		}
+	}
+	for _, name := range unitsToStart {
+		fnlog.Infof("starting unit %q", name)
+		if err := p.unitManager.TriggerStart(name); err != nil {
+			fnlog.Errorf("failed to trigger start for unit %q: %s", name, err)
+		}
+	}
+	p.postStartHooks(pod, unitsToStart)
+}
+
+// checkEnvironment resolves the environment of the container with name, see containerEnvironment.
+func (p *p) checkEnvironment(pod *corev1.Pod, name string) error {
+	for _, c := range append(pod.Spec.InitContainers, pod.Spec.Containers...) {
+		if c.Name != name {
+			continue
+		}
+		if _, err := p.containerEnvironment(pod, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setupHostPaths checks the hostPath volumes mounted by the container with name, and stages their subPaths.
+func (p *p) setupHostPaths(pod *corev1.Pod, name string) error {
+	uid, gid, err := uidGidFromSecurityContext(pod, p.config.OverrideRootUID)
+	if err != nil {
+		return err
+	}
+	hostPaths := map[string]*corev1.HostPathVolumeSource{}
+	for _, v := range pod.Spec.Volumes {
+		if v.HostPath != nil {
+			hostPaths[v.Name] = v.HostPath
+		}
+	}
+	for _, c := range append(pod.Spec.InitContainers, pod.Spec.Containers...) {
+		if c.Name != name {
+			continue
+		}
+		var env []corev1.EnvVar
+		for i, v := range c.VolumeMounts {
+			hp, ok := hostPaths[v.Name]
+			if !ok {
+				continue
+			}
+			if err := checkHostPath(hp, uid, gid); err != nil {
+				return fmt.Errorf("hostPath volume %s: %s", v.Name, err)
+			}
+			if v.SubPath == "" && v.SubPathExpr == "" {
+				continue
+			}
+			if env == nil {
+				if env, err = p.containerEnvironment(pod, c); err != nil {
+					return err
+				}
+			}
+			sub, err := subPath(v, env)
+			if err != nil {
+				return err
+			}
+			if _, err := stageSubPath(pod, c, i, hp.Path, sub, uid, gid); err != nil {
+				return fmt.Errorf("hostPath volume %s: %s", v.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// appUnitsToStart returns the units of pod's app containers, if none of its units is waiting.
+func (p *p) appUnitsToStart(pod *corev1.Pod) ([]string, error) {
+	states, err := p.unitStates(unitPrefix(pod.Namespace, pod.Name) + separator)
+	if err != nil {
+		return nil, err
+	}
+	for name, s := range states {
+		if uf, err := unit.NewFile(s.UnitData); err == nil && unitWaiting(uf) != nil {
+			return nil, fmt.Errorf("unit %q is waiting", name)
+		}
+	}
+	units := []string{}
+	for _, c := range pod.Spec.Containers {
+		units = append(units, podToUnitName(pod, c.Name))
+	}
+	return units, nil
+}
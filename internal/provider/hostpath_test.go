@@ -0,0 +1,194 @@
+package provider
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/virtual-kubelet/systemk/internal/kubernetes"
+	"github.com/virtual-kubelet/systemk/internal/ospkg"
+	"github.com/virtual-kubelet/systemk/internal/unit"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/informers"
+)
+
+func TestCheckHostPath(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(file, []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	socket := filepath.Join(dir, "socket")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	tests := []struct {
+		path string
+		typ  corev1.HostPathType
+		err  bool
+	}{
+		{filepath.Join(dir, "missing"), corev1.HostPathUnset, false},
+		{dir, corev1.HostPathDirectory, false},
+		{file, corev1.HostPathDirectory, true},
+		{filepath.Join(dir, "missing"), corev1.HostPathDirectory, true},
+		{filepath.Join(dir, "new/dir"), corev1.HostPathDirectoryOrCreate, false},
+		{file, corev1.HostPathDirectoryOrCreate, true},
+		{file, corev1.HostPathFile, false},
+		{dir, corev1.HostPathFile, true},
+		{filepath.Join(dir, "new/file"), corev1.HostPathFileOrCreate, false},
+		{socket, corev1.HostPathSocket, false},
+		{file, corev1.HostPathSocket, true},
+		{"/dev/null", corev1.HostPathCharDev, false},
+		{"/dev/null", corev1.HostPathBlockDev, true},
+	}
+	for i, tc := range tests {
+		typ := tc.typ
+		err := checkHostPath(&corev1.HostPathVolumeSource{Path: tc.path, Type: &typ}, "", "")
+		if tc.err != (err != nil) {
+			t.Errorf("test %d, expected error to be %t, got %v", i, tc.err, err)
+		}
+	}
+	if fi, err := os.Stat(filepath.Join(dir, "new/dir")); err != nil || !fi.IsDir() {
+		t.Errorf("expected directory to be created, got %v", err)
+	}
+	if fi, err := os.Stat(filepath.Join(dir, "new/file")); err != nil || !fi.Mode().IsRegular() {
+		t.Errorf("expected file to be created, got %v", err)
+	}
+}
+
+func TestHostPathVolume(t *testing.T) {
+	log = &noopLogger{}
+	p := new(p)
+	p.pkgManager = &ospkg.NoopManager{}
+	mock, _ := unit.NewMockManager()
+	rec := &restartRecorder{Manager: mock}
+	p.unitManager = rec
+	p.config = &Opts{NodeName: "localhost"}
+	p.podResourceManager = kubernetes.NewPodResourceWatcher(informers.NewSharedInformerFactory(nil, 0))
+
+	dir := t.TempDir()
+	directory := corev1.HostPathDirectory
+	pod := &corev1.Pod{}
+	pod.Namespace, pod.Name, pod.UID = "default", "hostpath", "aa-bb"
+	pod.Spec.Volumes = []corev1.Volume{
+		{Name: "data", VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: dir, Type: &directory}}},
+		{Name: "missing", VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: filepath.Join(dir, "missing"), Type: &directory}}},
+	}
+	pod.Spec.Containers = []corev1.Container{
+		{Name: "a", Image: "bash", VolumeMounts: []corev1.VolumeMount{{Name: "data", MountPath: "/srv/data"}}},
+		{Name: "b", Image: "bash", VolumeMounts: []corev1.VolumeMount{{Name: "missing", MountPath: "/srv/data"}}},
+	}
+	if err := p.CreatePod(context.TODO(), pod); err != nil {
+		t.Fatal(err)
+	}
+	defer p.DeletePod(context.TODO(), pod)
+
+	uf, _ := unit.NewFile(mock.Unit(podToUnitName(pod, "a")))
+	if x := lastValue(uf, "Service", "BindPaths"); x != dir+":/srv/data" {
+		t.Errorf("expected %s to be bind mounted on /srv/data, got BindPaths=%q", dir, x)
+	}
+	if len(rec.started) != 1 || rec.started[0] != podToUnitName(pod, "a") {
+		t.Errorf("expected only the unit of container a to be started, got %v", rec.started)
+	}
+
+	status, err := p.GetPodStatus(context.TODO(), pod.Namespace, pod.Name)
+	if err != nil || status == nil {
+		t.Fatalf("expected pod status, got %v", err)
+	}
+	for _, cs := range status.ContainerStatuses {
+		if cs.Name != "b" {
+			continue
+		}
+		if w := cs.State.Waiting; w == nil || w.Reason != createContainerError || !strings.Contains(w.Message, "does not exist") {
+			t.Errorf("expected container b to be waiting with reason %s, got %v", createContainerError, cs.State)
+		}
+	}
+
+	// Once the path exists the unit is started, without regenerating the Pod's other units.
+	rec.started = nil
+	p.checkWaiting(context.TODO())
+	if len(rec.started) != 0 {
+		t.Errorf("expected no units to be started while the path is missing, got %v", rec.started)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "missing"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	p.checkWaiting(context.TODO())
+	if len(rec.started) != 1 || rec.started[0] != podToUnitName(pod, "b") {
+		t.Errorf("expected the unit of container b to be started, got %v", rec.started)
+	}
+	if len(rec.restarted) != 0 {
+		t.Errorf("expected no units to be restarted, got %v", rec.restarted)
+	}
+	uf, _ = unit.NewFile(mock.Unit(podToUnitName(pod, "b")))
+	if w := unitWaiting(uf); w != nil {
+		t.Errorf("expected container b not to be waiting, got %v", w)
+	}
+	if x := lastValue(uf, "Service", "BindPaths"); x != filepath.Join(dir, "missing")+":/srv/data" {
+		t.Errorf("expected the hostPath to be bind mounted on /srv/data, got BindPaths=%q", x)
+	}
+}
+
+func TestRetryWaitingUnitDeleted(t *testing.T) {
+	log = &noopLogger{}
+	p := new(p)
+	p.pkgManager = &ospkg.NoopManager{}
+	mock, _ := unit.NewMockManager()
+	rec := &restartRecorder{Manager: mock}
+	p.unitManager = rec
+	p.config = &Opts{NodeName: "localhost"}
+	p.podResourceManager = kubernetes.NewPodResourceWatcher(informers.NewSharedInformerFactory(nil, 0))
+
+	dir := t.TempDir()
+	directory := corev1.HostPathDirectory
+	pod := &corev1.Pod{}
+	pod.Namespace, pod.Name, pod.UID = "default", "hostpath", "cc-dd"
+	pod.Spec.Volumes = []corev1.Volume{
+		{Name: "missing", VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: filepath.Join(dir, "missing"), Type: &directory}}},
+	}
+	pod.Spec.Containers = []corev1.Container{
+		{Name: "a", Image: "bash", VolumeMounts: []corev1.VolumeMount{{Name: "missing", MountPath: "/srv/data"}}},
+	}
+	if err := p.CreatePod(context.TODO(), pod); err != nil {
+		t.Fatal(err)
+	}
+	name := podToUnitName(pod, "a")
+
+	// The retry waits for the pod's lock.
+	unlock := p.locks.lock(pod.UID)
+	done := make(chan struct{})
+	go func() {
+		p.retryWaitingUnit(context.TODO(), pod.UID, name)
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("expected the retry to wait for the pod's lock")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock()
+	<-done
+
+	// Once the pod is deleted there's nothing to retry, even if the path exists now.
+	if err := p.DeletePod(context.TODO(), pod); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "missing"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	p.retryWaitingUnit(context.TODO(), pod.UID, name)
+	if len(rec.started) != 0 {
+		t.Errorf("expected the unit of the deleted pod not to be started, got %v", rec.started)
+	}
+	if _, err := os.Stat(filepath.Join(varrun, string(pod.UID))); !os.IsNotExist(err) {
+		t.Errorf("expected the pod's directory to stay removed, got %v", err)
+	}
+}
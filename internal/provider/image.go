@@ -0,0 +1,48 @@
+package provider
+
+import (
+	"errors"
+	"fmt"
+	"path"
+
+	"github.com/virtual-kubelet/systemk/internal/ospkg"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// pullImage makes sure the package of container c is installed, honouring the image pull policy:
+//
+// * Always upgrades the package (when pull is true), to the version in the image's tag or the newest one;
+// * IfNotPresent installs the package if it, or the version in the tag, isn't installed;
+// * Never doesn't install anything and fails if the package isn't installed.
+//
+// It returns true if the package was installed and the version that is installed, if known.
+func (p *p) pullImage(c corev1.Container, pull bool) (bool, string, error) {
+	pkg, version := ospkg.ParseImage(c.Image)
+	if path.IsAbs(pkg) {
+		return false, "", nil
+	}
+
+	installed := false
+	var err error
+	switch c.ImagePullPolicy {
+	case corev1.PullNever:
+		v, err := p.pkgManager.Version(ospkg.Clean(pkg))
+		if errors.Is(err, ospkg.ErrNotInstalled) {
+			return false, "", fmt.Errorf("package %q is not installed and image pull policy is %s", pkg, corev1.PullNever)
+		}
+		return false, v, err
+	case corev1.PullAlways:
+		if pull {
+			installed, err = p.pkgManager.Upgrade(pkg, version)
+			break
+		}
+		fallthrough
+	default: // IfNotPresent
+		installed, err = p.pkgManager.Install(pkg, version)
+	}
+	if err != nil {
+		return false, "", err
+	}
+	v, _ := p.pkgManager.Version(ospkg.Clean(pkg))
+	return installed, v, nil
+}
@@ -0,0 +1,68 @@
+package provider
+
+import (
+	"testing"
+
+	"github.com/virtual-kubelet/systemk/internal/ospkg"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// fakePkgManager has a single package, bash, installed.
+type fakePkgManager struct {
+	ospkg.NoopManager
+	version  string
+	upgraded bool
+}
+
+func (f *fakePkgManager) Install(pkg, version string) (bool, error) {
+	if f.version != "" && (version == "" || version == f.version) {
+		return false, nil
+	}
+	f.version = version
+	return true, nil
+}
+
+func (f *fakePkgManager) Upgrade(pkg, version string) (bool, error) {
+	f.upgraded = true
+	f.version = "5.1-3"
+	return true, nil
+}
+
+func (f *fakePkgManager) Version(pkg string) (string, error) {
+	if f.version == "" {
+		return "", ospkg.ErrNotInstalled
+	}
+	return f.version, nil
+}
+
+func TestPullImage(t *testing.T) {
+	p := new(p)
+	fake := &fakePkgManager{}
+	p.pkgManager = fake
+
+	c := corev1.Container{Name: "bash", Image: "bash", ImagePullPolicy: corev1.PullNever}
+	if _, _, err := p.pullImage(c, true); err == nil {
+		t.Errorf("expected error for pull policy Never and package not installed")
+	}
+
+	c.Image, c.ImagePullPolicy = "bash:5.0-6", corev1.PullIfNotPresent
+	installed, version, err := p.pullImage(c, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !installed || version != "5.0-6" {
+		t.Errorf("expected bash 5.0-6 to be installed, got %t and %q", installed, version)
+	}
+
+	c.ImagePullPolicy = corev1.PullAlways
+	if _, _, err := p.pullImage(c, false); err != nil {
+		t.Fatal(err)
+	}
+	if fake.upgraded {
+		t.Errorf("expected no upgrade when not pulling")
+	}
+	c.Image = "bash"
+	if _, version, _ = p.pullImage(c, true); version != "5.1-3" {
+		t.Errorf("expected bash to be upgraded to %q, got %q", "5.1-3", version)
+	}
+}
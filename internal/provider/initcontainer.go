@@ -0,0 +1,75 @@
+package provider
+
+import (
+	"github.com/virtual-kubelet/systemk/internal/unit"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// podInitializing is the reason given for a container that waits for the init containers to complete.
+const podInitializing = "PodInitializing"
+
+// initContainerToUnit makes uf, the unit of an init container, a oneshot that is kept around after it exited. A
+// failed init container is retried unless the Pod's restart policy is Never, with the same backoff as other units.
+// Like the kubelet, Always is treated as OnFailure: an init container that succeeded isn't started again.
+func initContainerToUnit(uf *unit.File, policy corev1.RestartPolicy) *unit.File {
+	uf = uf.Overwrite("Service", "Type", "oneshot")
+	uf = uf.Insert(kubernetesSection, "InitContainer", "true")
+	// Keep the unit around until DeletePod is called, this is also what lets the app containers depend on it.
+	uf = uf.Overwrite("Service", "RemainAfterExit", "true")
+	if policy == corev1.RestartPolicyNever {
+		return uf.Overwrite("Service", "Restart", "no")
+	}
+	uf = uf.Overwrite("Service", "Restart", "on-failure")
+	uf = uf.Overwrite("Service", "RestartSec", restartSec)
+	uf = uf.Overwrite("Service", "RestartSteps", restartSteps)
+	uf = uf.Overwrite("Service", "RestartMaxDelaySec", restartMaxDelaySec)
+	return uf
+}
+
+// unitChanged is called by the unit cache whenever unit name changed.
+func (p *p) unitChanged(name string) {
+	p.notifier.changed(name)
+	p.startAfterInit(name)
+}
+
+// startAfterInit starts the app containers of the Pod of unit name, once all of the Pod's init containers have
+// completed successfully. The app containers' units require the last init container, so when that one fails the
+// start jobs queued in CreatePod fail too; if the init container is then restarted by systemd and succeeds, nothing
+// would start them.
+func (p *p) startAfterInit(name string) {
+	s, err := p.unitState(name)
+	if err != nil || s == nil || s.SubState != "exited" || p.serviceResult(name) != "success" {
+		return
+	}
+	uf, err := unit.NewFile(s.UnitData)
+	if err != nil || uf.Contents[kubernetesSection]["InitContainer"] == nil {
+		return
+	}
+
+	states, err := p.unitStates(unitPrefix(Namespace(name), Pod(name)) + separator)
+	if err != nil {
+		return
+	}
+	apps := []string{}
+	for _, k := range unitNames(states) {
+		u, err := unit.NewFile(states[k].UnitData)
+		if err != nil {
+			continue
+		}
+		if u.Contents[kubernetesSection]["InitContainer"] == nil {
+			if states[k].SubState == "dead" {
+				apps = append(apps, k)
+			}
+			continue
+		}
+		if states[k].SubState != "exited" || p.serviceResult(k) != "success" {
+			return
+		}
+	}
+	for _, k := range apps {
+		log.Infof("init containers completed, starting unit %q", k)
+		if err := p.unitManager.TriggerStart(k); err != nil {
+			log.Errorf("failed to trigger start for unit %q: %s", k, err)
+		}
+	}
+}
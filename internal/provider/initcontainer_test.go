@@ -0,0 +1,93 @@
+package provider
+
+import (
+	"context"
+	"testing"
+
+	"github.com/virtual-kubelet/systemk/internal/kubernetes"
+	"github.com/virtual-kubelet/systemk/internal/ospkg"
+	"github.com/virtual-kubelet/systemk/internal/unit"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/informers"
+)
+
+func TestInitContainers(t *testing.T) {
+	log = &noopLogger{}
+	p := new(p)
+	p.pkgManager = &ospkg.NoopManager{}
+	mock, _ := unit.NewMockManager()
+	rec := &restartRecorder{Manager: mock}
+	p.unitManager = rec
+	p.config = &Opts{NodeName: "localhost"}
+	p.podResourceManager = kubernetes.NewPodResourceWatcher(informers.NewSharedInformerFactory(nil, 0))
+
+	pod := &corev1.Pod{}
+	pod.Namespace, pod.Name, pod.UID = "default", "init", "aa-bb"
+	pod.Spec.InitContainers = []corev1.Container{{Name: "i1", Image: "bash"}, {Name: "i2", Image: "bash"}}
+	pod.Spec.Containers = []corev1.Container{{Name: "a", Image: "bash"}}
+	if err := p.CreatePod(context.TODO(), pod); err != nil {
+		t.Fatal(err)
+	}
+	if len(rec.started) != 1 || rec.started[0] != podToUnitName(pod, "a") {
+		t.Errorf("expected only the unit of container a to be started, got %v", rec.started)
+	}
+
+	states, _ := p.unitStates(unitPrefix(pod.Namespace, pod.Name) + separator)
+	requires := map[string]string{
+		"i1": "",
+		"i2": podToUnitName(pod, "i1"),
+		"a":  podToUnitName(pod, "i2"),
+	}
+	for c, req := range requires {
+		uf, _ := unit.NewFile(states[podToUnitName(pod, c)].UnitData)
+		if x := lastValue(uf, "Unit", "Requires"); x != req {
+			t.Errorf("expected unit of container %s to require %q, got %q", c, req, x)
+		}
+		if x := lastValue(uf, "Unit", "After"); x != req {
+			t.Errorf("expected unit of container %s to be after %q, got %q", c, req, x)
+		}
+	}
+	uf, _ := unit.NewFile(states[podToUnitName(pod, "i1")].UnitData)
+	if x := lastValue(uf, "Service", "Restart"); x != "on-failure" {
+		t.Errorf("expected init container to be restarted on failure, got Restart=%q", x)
+	}
+}
+
+func TestInitContainerToUnit(t *testing.T) {
+	tests := []struct {
+		policy  corev1.RestartPolicy
+		restart string
+	}{
+		{corev1.RestartPolicyAlways, "on-failure"},
+		{corev1.RestartPolicyOnFailure, "on-failure"},
+		{corev1.RestartPolicyNever, "no"},
+	}
+	for i, tc := range tests {
+		uf, _ := unit.NewFile(synthUnit)
+		uf = initContainerToUnit(uf, tc.policy)
+		if x := lastValue(uf, "Service", "Restart"); x != tc.restart {
+			t.Errorf("test %d, expected Restart to be %q, got %q", i, tc.restart, x)
+		}
+		if x := lastValue(uf, "Service", "Type"); x != "oneshot" {
+			t.Errorf("test %d, expected Type to be oneshot, got %q", i, x)
+		}
+		if x := lastValue(uf, "Service", "RemainAfterExit"); x != "true" {
+			t.Errorf("test %d, expected RemainAfterExit to be true, got %q", i, x)
+		}
+	}
+}
+
+func TestInitializing(t *testing.T) {
+	status := []corev1.ContainerStatus{
+		{State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{}}},
+		{State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: crashLoopBackOff}}},
+		{State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "dead"}}},
+	}
+	initializing(status)
+	if status[1].State.Waiting.Reason != crashLoopBackOff {
+		t.Errorf("expected %s, got %s", crashLoopBackOff, status[1].State.Waiting.Reason)
+	}
+	if status[2].State.Waiting.Reason != podInitializing {
+		t.Errorf("expected %s, got %s", podInitializing, status[2].State.Waiting.Reason)
+	}
+}
@@ -0,0 +1,197 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/virtual-kubelet/systemk/internal/unit"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// defaultGracePeriod is the API server's default for terminationGracePeriodSeconds.
+	defaultGracePeriod = 30
+
+	// postStartTimeout is how long we wait for a unit to be running and its (non-exec) postStart hook to succeed.
+	postStartTimeout = 2 * time.Minute
+
+	// minStopGracePeriod is the least time the units get to stop after the preStop hooks ran, as with the kubelet.
+	minStopGracePeriod = 2 * time.Second
+
+	// killTimeout is how long we wait for the units to stop after they got SIGKILL.
+	killTimeout = 5 * time.Second
+
+	// stopPollInterval is how often waitStopped checks the states of the units.
+	stopPollInterval = 250 * time.Millisecond
+)
+
+// lifecycleToUnit translates the container's lifecycle hooks and the Pod's termination grace period into the unit:
+//
+// * an exec postStart hook becomes ExecStartPost=, when it fails systemd stops the unit;
+// * the grace period becomes TimeoutStopSec=, after which systemd escalates to SIGKILL.
+//
+// The other postStart hooks and all preStop hooks are run by systemk, see postStartHooks and preStopHooks. A preStop
+// hook can't be ExecStop=: systemd also runs that when the main process exits on its own, e.g. on every crash of a
+// unit with Restart=always, while the kubelet only runs it when the container is terminated.
+func lifecycleToUnit(uf *unit.File, c corev1.Container, grace *int64) *unit.File {
+	if l := c.Lifecycle; l != nil {
+		if l.PostStart != nil && l.PostStart.Exec != nil && len(l.PostStart.Exec.Command) > 0 {
+			uf = uf.Overwrite("Service", "ExecStartPost", hookCommand(l.PostStart.Exec.Command))
+		}
+	}
+
+	seconds := int64(gracePeriod(grace) / time.Second)
+	uf = uf.Overwrite("Service", "SendSIGKILL", "yes")
+	if seconds == 0 {
+		// TimeoutStopSec=0 disables the timeout, kill right away instead.
+		uf = uf.Overwrite("Service", "KillSignal", "SIGKILL")
+		return uf
+	}
+	uf = uf.Overwrite("Service", "KillSignal", "SIGTERM")
+	uf = uf.Overwrite("Service", "TimeoutStopSec", strconv.FormatInt(seconds, 10))
+	return uf
+}
+
+// hookCommand returns command as a systemd command line.
+func hookCommand(command []string) string {
+	cmd := command[0]
+	if !path.IsAbs(cmd) {
+		if fullpath, err := exec.LookPath(cmd); err == nil {
+			cmd = fullpath
+		}
+	}
+	args := []string{cmd}
+	for _, a := range command[1:] {
+		args = append(args, fmt.Sprintf("%q", a))
+	}
+	return strings.Join(args, " ")
+}
+
+// gracePeriod returns the termination grace period, grace is in seconds.
+func gracePeriod(grace *int64) time.Duration {
+	if grace == nil || *grace < 0 {
+		return defaultGracePeriod * time.Second
+	}
+	return time.Duration(*grace) * time.Second
+}
+
+// postStartHooks runs the postStart hooks that aren't handled by systemd, i.e. httpGet and tcpSocket, of the (just
+// started) units once they are running. If a hook fails the unit is restarted, like the kubelet kills the container.
+func (p *p) postStartHooks(pod *corev1.Pod, units []string) {
+	started := map[string]bool{}
+	for _, name := range units {
+		started[name] = true
+	}
+	for _, c := range pod.Spec.Containers {
+		if c.Lifecycle == nil || c.Lifecycle.PostStart == nil || c.Lifecycle.PostStart.Exec != nil {
+			continue
+		}
+		name := podToUnitName(pod, c.Name)
+		if !started[name] {
+			continue
+		}
+		go func(c corev1.Container) {
+			ctx, cancel := context.WithTimeout(context.Background(), postStartTimeout)
+			defer cancel()
+
+			tick := time.NewTicker(1 * time.Second)
+			defer tick.Stop()
+			for {
+				if _, running := p.unitRunning(name); running {
+					break
+				}
+				select {
+				case <-ctx.Done():
+					log.Warnf("unit %q not running, not running postStart hook", name)
+					return
+				case <-tick.C:
+				}
+			}
+			deadline, _ := ctx.Deadline()
+			if err := p.runHandler(ctx, name, c, *c.Lifecycle.PostStart, time.Until(deadline)); err != nil {
+				log.Warnf("postStart hook of unit %q failed, restarting: %s", name, err)
+				if err := p.unitManager.TriggerRestart(name); err != nil {
+					log.Errorf("failed to trigger restart for unit %q: %s", name, err)
+				}
+			}
+		}(c)
+	}
+}
+
+// preStopHooks runs the preStop hooks of the running units and waits for them to finish. An exec hook runs in the
+// unit's execution context, see unitCommand. They get at most grace, the Pod's grace period.
+func (p *p) preStopHooks(ctx context.Context, pod *corev1.Pod, grace time.Duration) {
+	var wg sync.WaitGroup
+	for _, c := range pod.Spec.Containers {
+		if c.Lifecycle == nil || c.Lifecycle.PreStop == nil {
+			continue
+		}
+		name := podToUnitName(pod, c.Name)
+		if _, running := p.unitRunning(name); !running {
+			continue
+		}
+		wg.Add(1)
+		go func(c corev1.Container) {
+			defer wg.Done()
+			if err := p.runHandler(ctx, name, c, *c.Lifecycle.PreStop, grace); err != nil {
+				log.Warnf("preStop hook of unit %q failed: %s", name, err)
+			}
+		}(c)
+	}
+	wg.Wait()
+}
+
+// waitStopped waits until deadline for the units to be stopped, i.e. no longer active, activating, reloading or
+// deactivating. The stopping units get SIGKILL at the deadline: the preStop hooks run by systemk take from the
+// grace period too, so systemd's TimeoutStopSec= may not have passed yet.
+func (p *p) waitStopped(ctx context.Context, units []string, deadline time.Time) {
+	stopping := p.stopping(ctx, units, deadline)
+	if len(stopping) == 0 {
+		return
+	}
+	for _, name := range stopping {
+		log.Warnf("unit %q didn't stop within the grace period, killing it", name)
+		if err := p.unitManager.Kill(name, syscall.SIGKILL); err != nil {
+			log.Errorf("failed to kill unit %q: %s", name, err)
+		}
+	}
+	for _, name := range p.stopping(ctx, stopping, time.Now().Add(killTimeout)) {
+		log.Warnf("unit %q is still stopping", name)
+	}
+}
+
+// stopping polls the states of units until they're all stopped, or until deadline, and returns the ones that are
+// still stopping.
+func (p *p) stopping(ctx context.Context, units []string, deadline time.Time) []string {
+	tick := time.NewTicker(stopPollInterval)
+	defer tick.Stop()
+	for {
+		stopping := []string{}
+		for _, name := range units {
+			s, err := p.unitState(name)
+			if err != nil || s == nil {
+				continue
+			}
+			switch s.ActiveState {
+			case "active", "activating", "reloading", "deactivating":
+				stopping = append(stopping, name)
+			}
+		}
+		if len(stopping) == 0 || !time.Now().Before(deadline) {
+			return stopping
+		}
+		units = stopping
+		select {
+		case <-ctx.Done():
+			return stopping
+		case <-tick.C:
+		}
+	}
+}
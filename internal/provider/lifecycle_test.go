@@ -0,0 +1,230 @@
+package provider
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/virtual-kubelet/systemk/internal/kubernetes"
+	"github.com/virtual-kubelet/systemk/internal/ospkg"
+	"github.com/virtual-kubelet/systemk/internal/unit"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/informers"
+)
+
+func TestLifecycleToUnit(t *testing.T) {
+	c := corev1.Container{
+		Name: "db",
+		Lifecycle: &corev1.Lifecycle{
+			PostStart: &corev1.Handler{Exec: &corev1.ExecAction{Command: []string{"/bin/sh", "-c", "echo started"}}},
+			PreStop:   &corev1.Handler{HTTPGet: &corev1.HTTPGetAction{Path: "/shutdown"}},
+		},
+	}
+	grace := int64(60)
+	uf, _ := unit.NewFile(synthUnit)
+	uf = lifecycleToUnit(uf, c, &grace)
+
+	if x := lastValue(uf, "Service", "ExecStartPost"); x != `/bin/sh "-c" "echo started"` {
+		t.Errorf("expected ExecStartPost to be set, got %q", x)
+	}
+	if x := lastValue(uf, "Service", "ExecStop"); x != "" {
+		t.Errorf("expected no ExecStop for an httpGet hook, got %q", x)
+	}
+	c.Lifecycle.PreStop = &corev1.Handler{Exec: &corev1.ExecAction{Command: []string{"/bin/sh", "-c", "echo stopping"}}}
+	uf, _ = unit.NewFile(synthUnit)
+	uf = lifecycleToUnit(uf, c, &grace)
+	if x := lastValue(uf, "Service", "ExecStop"); x != "" {
+		t.Errorf("expected no ExecStop for an exec hook, got %q", x)
+	}
+	if x := lastValue(uf, "Service", "TimeoutStopSec"); x != "60" {
+		t.Errorf("expected TimeoutStopSec to be %q, got %q", "60", x)
+	}
+
+	grace = 0
+	uf, _ = unit.NewFile(synthUnit)
+	uf = lifecycleToUnit(uf, c, &grace)
+	if x := lastValue(uf, "Service", "KillSignal"); x != "SIGKILL" {
+		t.Errorf("expected KillSignal to be %q, got %q", "SIGKILL", x)
+	}
+	if x := lastValue(uf, "Service", "TimeoutStopSec"); x != "" {
+		t.Errorf("expected no TimeoutStopSec, got %q", x)
+	}
+}
+
+// slowStop reports the units as deactivating until they've been polled stops times, or until they're killed. It
+// records whether the Pod's directory still existed while the units were stopping.
+type slowStop struct {
+	unit.Manager
+	dir   string
+	stops int
+
+	mu       sync.Mutex
+	polls    int
+	killed   []string
+	dirGone  bool
+	stopping bool
+}
+
+func (s *slowStop) TriggerStop(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stopping = true
+	return nil
+}
+
+func (s *slowStop) Kill(name string, signal syscall.Signal) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if signal == syscall.SIGKILL {
+		s.killed = append(s.killed, name)
+	}
+	return nil
+}
+
+func (s *slowStop) State(name string) (*unit.State, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state := &unit.State{}
+	state.ActiveState = "active"
+	if !s.stopping {
+		return state, nil
+	}
+	if _, err := os.Stat(s.dir); err != nil {
+		s.dirGone = true
+	}
+	s.polls++
+	state.ActiveState = "deactivating"
+	if (s.stops > 0 && s.polls >= s.stops) || len(s.killed) > 0 {
+		state.ActiveState = "inactive"
+	}
+	return state, nil
+}
+
+func TestDeletePodWaitsForUnits(t *testing.T) {
+	log = &noopLogger{}
+	for _, tc := range []struct {
+		stops  int
+		grace  int64
+		killed bool
+	}{
+		{stops: 3, grace: 30, killed: false},
+		{stops: 0, grace: 0, killed: true},
+	} {
+		mock, _ := unit.NewMockManager()
+		pod := &corev1.Pod{}
+		pod.Namespace, pod.Name, pod.UID = "default", "stop", "st-op"
+		pod.Spec.TerminationGracePeriodSeconds = &tc.grace
+		pod.Spec.Containers = []corev1.Container{{Name: "a", Image: "bash"}}
+		m := &slowStop{Manager: mock, dir: filepath.Join(varrun, string(pod.UID)), stops: tc.stops}
+
+		p := new(p)
+		p.pkgManager = &ospkg.NoopManager{}
+		p.unitManager = m
+		p.config = &Opts{NodeName: "localhost"}
+		p.podResourceManager = kubernetes.NewPodResourceWatcher(informers.NewSharedInformerFactory(nil, 0))
+		if err := p.CreatePod(context.TODO(), pod); err != nil {
+			t.Fatal(err)
+		}
+
+		start := time.Now()
+		if err := p.DeletePod(context.TODO(), pod); err != nil {
+			t.Fatal(err)
+		}
+		if m.dirGone {
+			t.Errorf("grace %d, expected the pod's directory to exist while its units were stopping", tc.grace)
+		}
+		if _, err := os.Stat(m.dir); !os.IsNotExist(err) {
+			t.Errorf("grace %d, expected the pod's directory to be removed, got %v", tc.grace, err)
+		}
+		if killed := len(m.killed) > 0; killed != tc.killed {
+			t.Errorf("grace %d, expected killed to be %t, got %v", tc.grace, tc.killed, m.killed)
+		}
+		if tc.killed && time.Since(start) < minStopGracePeriod {
+			t.Errorf("grace %d, expected the units to get at least %s to stop, got %s", tc.grace, minStopGracePeriod, time.Since(start))
+		}
+	}
+}
+
+// selfUnits reports the units as running this process until they're stopped.
+type selfUnits struct {
+	*restartRecorder
+	stopped bool
+}
+
+func (s *selfUnits) TriggerStop(name string) error {
+	s.stopped = true
+	return nil
+}
+
+func (s *selfUnits) State(name string) (*unit.State, error) {
+	state, err := s.restartRecorder.State(name)
+	if state != nil && !s.stopped {
+		state.ActiveState, state.SubState = "active", "running"
+	}
+	return state, err
+}
+
+func (s *selfUnits) ServiceProperty(name, property string) string {
+	if property == "MainPID" && !s.stopped {
+		return strconv.Itoa(os.Getpid())
+	}
+	return s.restartRecorder.ServiceProperty(name, property)
+}
+
+func TestPreStopHookOnlyOnDelete(t *testing.T) {
+	log = &noopLogger{}
+	mock, _ := unit.NewMockManager()
+	m := &selfUnits{restartRecorder: &restartRecorder{Manager: mock}}
+	p := new(p)
+	p.pkgManager = &ospkg.NoopManager{}
+	p.unitManager = m
+	p.config = &Opts{NodeName: "localhost"}
+	p.podResourceManager = kubernetes.NewPodResourceWatcher(informers.NewSharedInformerFactory(nil, 0))
+
+	stopped := filepath.Join(t.TempDir(), "stopped")
+	hook := corev1.Handler{Exec: &corev1.ExecAction{Command: []string{"/bin/sh", "-c", "echo x >> " + stopped}}}
+	pod := &corev1.Pod{}
+	pod.Namespace, pod.Name, pod.UID = "default", "prestop", "pre-stop"
+	pod.Spec.RestartPolicy = corev1.RestartPolicyAlways
+	pod.Spec.Containers = []corev1.Container{{Name: "a", Image: "bash", Lifecycle: &corev1.Lifecycle{PreStop: &hook}}}
+	if err := p.CreatePod(context.TODO(), pod); err != nil {
+		t.Fatal(err)
+	}
+	name := podToUnitName(pod, "a")
+	if err := p.runHandler(context.TODO(), name, pod.Spec.Containers[0], corev1.Handler{Exec: &corev1.ExecAction{Command: []string{"true"}}}, time.Second); err != nil {
+		p.DeletePod(context.TODO(), pod)
+		t.Skipf("can't run commands in the unit's context: %s", err)
+	}
+
+	// systemd restarts a crashed unit on its own: nothing in the unit may run the hook.
+	uf, _ := unit.NewFile(mock.Unit(name))
+	for _, key := range []string{"ExecStop", "ExecStopPost"} {
+		if x := lastValue(uf, "Service", key); x != "" {
+			t.Errorf("expected no %s, got %q", key, x)
+		}
+	}
+	// Neither does a restart by systemk.
+	pod.Annotations = map[string]string{restartedAtAnnotation: "2021-06-01T00:00:00Z"}
+	if err := p.UpdatePod(context.TODO(), pod); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.restarted) != 1 {
+		t.Fatalf("expected the unit to be restarted, got %v", m.restarted)
+	}
+	if _, err := os.Stat(stopped); !os.IsNotExist(err) {
+		t.Errorf("expected the preStop hook not to run on a restart, got %v", err)
+	}
+
+	if err := p.DeletePod(context.TODO(), pod); err != nil {
+		t.Fatal(err)
+	}
+	if buf, err := ioutil.ReadFile(stopped); err != nil || string(buf) != "x\n" {
+		t.Errorf("expected the preStop hook to run once on delete, got %q (%v)", buf, err)
+	}
+}
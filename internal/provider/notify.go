@@ -0,0 +1,145 @@
+package provider
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// notifyDelay is how long changes are collected before the Pods' statuses are sent. Starting or stopping a unit
+	// changes a bunch of its properties in quick succession, this makes that one update.
+	notifyDelay = 100 * time.Millisecond
+
+	// resyncInterval is the interval in which the statuses of all Pods are sent, regardless of changes.
+	resyncInterval = 1 * time.Minute
+)
+
+// notifier pushes Pod statuses to the pod controller whenever one of the Pod's units changes.
+type notifier struct {
+	p *p
+
+	mu      sync.Mutex
+	notify  func(*corev1.Pod)
+	pending map[string]struct{} // unit prefixes of the Pods that changed
+	kick    chan struct{}
+}
+
+func newNotifier(p *p) *notifier {
+	return &notifier{p: p, pending: make(map[string]struct{}), kick: make(chan struct{}, 1)}
+}
+
+// NotifyPods implements node.PodNotifier. The pod controller then no longer polls GetPodStatus, instead the unit
+// cache follows systemd's PropertiesChanged signals for our units and we send the status of the Pod as soon as one
+// of them changes.
+func (p *p) NotifyPods(ctx context.Context, f func(*corev1.Pod)) {
+	if p.notifier == nil {
+		p.notifier = newNotifier(p)
+	}
+	n := p.notifier
+	n.mu.Lock()
+	n.notify = f
+	n.mu.Unlock()
+	go n.run(ctx)
+}
+
+// changed marks the Pod that unit name belongs to as changed.
+func (n *notifier) changed(name string) {
+	if n == nil || !strings.HasPrefix(name, prefix+separator) {
+		return
+	}
+	namespace, pod := Namespace(name), Pod(name)
+	if namespace == "" || pod == "" {
+		return
+	}
+	n.mu.Lock()
+	n.pending[unitPrefix(namespace, pod)] = struct{}{}
+	n.mu.Unlock()
+	select {
+	case n.kick <- struct{}{}:
+	default:
+	}
+}
+
+func (n *notifier) run(ctx context.Context) {
+	resync := time.NewTicker(resyncInterval)
+	defer resync.Stop()
+	var delay <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-n.kick:
+			if delay == nil {
+				delay = time.After(notifyDelay)
+			}
+		case <-delay:
+			delay = nil
+			n.flush(ctx)
+		case <-resync.C:
+			pods, err := n.p.GetPods(ctx)
+			if err != nil {
+				log.Warnf("failed to list pods: %s", err)
+				continue
+			}
+			for _, pod := range pods {
+				n.send(pod)
+			}
+		}
+	}
+}
+
+// flush sends the statuses of the Pods that changed.
+func (n *notifier) flush(ctx context.Context) {
+	n.mu.Lock()
+	pending := n.pending
+	n.pending = make(map[string]struct{})
+	n.mu.Unlock()
+
+	for up := range pending {
+		el := strings.Split(up, separator)
+		pod, err := n.p.GetPod(ctx, el[1], el[2])
+		if err != nil || pod == nil {
+			continue
+		}
+		n.send(pod)
+	}
+}
+
+func (n *notifier) send(pod *corev1.Pod) {
+	n.mu.Lock()
+	f := n.notify
+	n.mu.Unlock()
+	if f != nil {
+		f(pod)
+	}
+}
+
+// deleted sends a terminal status for pod, which has been deleted. The pod controller then removes it from the API
+// server. This mimics what the pod controller does for providers that don't implement node.PodNotifier.
+func (n *notifier) deleted(pod *corev1.Pod) {
+	if n == nil || pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+		return
+	}
+	updated := pod.DeepCopy()
+	updated.Status.Phase = corev1.PodSucceeded
+	updated.Status.Reason = "ProviderPodDeleted"
+	now := metav1.NewTime(time.Now())
+	for i, cs := range updated.Status.ContainerStatuses {
+		updated.Status.ContainerStatuses[i].State.Terminated = &corev1.ContainerStateTerminated{
+			Reason:     "ProviderPodDeleted",
+			Message:    "pod has been deleted",
+			FinishedAt: now,
+		}
+		if cs.State.Running != nil {
+			updated.Status.ContainerStatuses[i].State.Terminated.StartedAt = cs.State.Running.StartedAt
+		}
+		updated.Status.ContainerStatuses[i].State.Running = nil
+		updated.Status.ContainerStatuses[i].State.Waiting = nil
+	}
+	n.send(updated)
+}
@@ -0,0 +1,51 @@
+package provider
+
+import (
+	"context"
+	"testing"
+
+	"github.com/virtual-kubelet/systemk/internal/kubernetes"
+	"github.com/virtual-kubelet/systemk/internal/ospkg"
+	"github.com/virtual-kubelet/systemk/internal/unit"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/informers"
+)
+
+func TestNotifier(t *testing.T) {
+	log = &noopLogger{}
+	p := new(p)
+	p.pkgManager = &ospkg.NoopManager{}
+	p.unitManager, _ = unit.NewMockManager()
+	p.config = &Opts{NodeName: "localhost"}
+	p.podResourceManager = kubernetes.NewPodResourceWatcher(informers.NewSharedInformerFactory(nil, 0))
+	p.notifier = newNotifier(p)
+
+	var got []*corev1.Pod
+	p.notifier.notify = func(pod *corev1.Pod) { got = append(got, pod) }
+
+	pod := &corev1.Pod{}
+	pod.Namespace, pod.Name, pod.UID = "default", "notify", "aa-bb"
+	pod.Spec.Containers = []corev1.Container{{Name: "a", Image: "bash"}, {Name: "b", Image: "bash"}}
+	if err := p.CreatePod(context.TODO(), pod); err != nil {
+		t.Fatal(err)
+	}
+
+	// Changes to both units, and to a unit that isn't ours, should result in a single update.
+	p.notifier.changed(podToUnitName(pod, "a"))
+	p.notifier.changed(podToUnitName(pod, "b"))
+	p.notifier.changed("ssh.service")
+	p.notifier.flush(context.TODO())
+	if len(got) != 1 {
+		t.Fatalf("expected 1 pod status, got %d", len(got))
+	}
+	if got[0].Name != pod.Name || got[0].Namespace != pod.Namespace {
+		t.Errorf("expected status for %s/%s, got %s/%s", pod.Namespace, pod.Name, got[0].Namespace, got[0].Name)
+	}
+
+	status := got[0]
+	got = nil
+	p.notifier.deleted(status)
+	if len(got) != 1 || got[0].Status.Phase != corev1.PodSucceeded {
+		t.Errorf("expected a terminal status after deletion, got %v", got)
+	}
+}
@@ -34,6 +34,7 @@ var (
 	DefaultListenAddr            = ":10250"
 	DefaultPodSyncWorkers        = 10
 	DefaultKubeClusterDomain     = "cluster.local"
+	DefaultResolvConf            = "/etc/resolv.conf"
 	DefaultTaintKey              = "virtual-kubelet.io/provider"
 	DefaultTaintValue            = "systemk"
 	DefaultStreamIdleTimeout     = 30 * time.Second
@@ -70,6 +71,13 @@ type Opts struct {
 	// KubeClusterDomain is the suffix to append to search domains for the Pods.
 	KubeClusterDomain string
 
+	// ClusterDNS are the nameservers of Pods with the ClusterFirst DNS policy.
+	ClusterDNS []net.IP
+
+	// ResolvConf is the resolver configuration of the host, used for Pods with the Default DNS policy. If empty
+	// these Pods get no nameservers.
+	ResolvConf string
+
 	// KubernetesURL is the value to set for the KUBERNETES_SERVICE_* Pod env vars.
 	KubernetesURL string
 
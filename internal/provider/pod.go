@@ -14,6 +14,7 @@ import (
 	"github.com/virtual-kubelet/systemk/internal/unit"
 	nodeapi "github.com/virtual-kubelet/virtual-kubelet/node/api"
 	corev1 "k8s.io/api/core/v1"
+	utilexec "k8s.io/utils/exec"
 )
 
 // If any of these methods return an error, it will show up in the kubectl output as "ProviderFailed", so we should
@@ -24,7 +25,7 @@ func (p *p) GetPod(ctx context.Context, namespace, name string) (*corev1.Pod, er
 	fnlog := log.WithField("podNamespace", namespace).WithField("podName", name)
 	fnlog.Debug("GetPod called")
 	unitprefix := unitPrefix(namespace, name) + separator // we need to closing dot here, otherwise will return update2, update3, when looking for update.
-	stats, err := p.unitManager.States(unitprefix)
+	stats, err := p.unitStates(unitprefix)
 	if err != nil {
 		fnlog.Errorf("failed to retrieve systemd states respective to Pod: %s", err)
 		return nil, nil
@@ -35,7 +36,7 @@ func (p *p) GetPod(ctx context.Context, namespace, name string) (*corev1.Pod, er
 
 func (p *p) GetPods(ctx context.Context) ([]*corev1.Pod, error) {
 	log.Debug("GetPods called")
-	states, err := p.unitManager.States(prefix)
+	states, err := p.unitStates(prefix + separator)
 	if err != nil {
 		return nil, err
 	}
@@ -47,16 +48,21 @@ func (p *p) GetPods(ctx context.Context) ([]*corev1.Pod, error) {
 	ns := map[string][]string{} // namespace/ pod(s) mapping
 
 	// sort unit by namespace/name
+	seen := map[string]bool{}
 	for name := range states {
 		namespace := Namespace(name)
 		pod := Pod(name)
+		if seen[namespace+separator+pod] {
+			continue
+		}
+		seen[namespace+separator+pod] = true
 		ns[namespace] = append(ns[namespace], pod)
 	}
 
 	var pods []*corev1.Pod
 	for namespace, names := range ns {
 		for _, name := range names {
-			if pod, err := p.GetPod(ctx, namespace, name); err != nil {
+			if pod, err := p.GetPod(ctx, namespace, name); err == nil && pod != nil {
 				pods = append(pods, pod)
 			}
 		}
@@ -70,70 +76,187 @@ func (p *p) CreatePod(ctx context.Context, pod *corev1.Pod) error {
 		WithField("podName", pod.Name)
 
 	fnlog.Info("CreatePod called")
+	defer p.locks.lock(pod.UID)()
+
+	units, err := p.podUnits(pod, true)
+	if err != nil {
+		return err
+	}
+	writePod(pod)
+
+	slice := podSlice(podQOS(pod), pod.Namespace, pod.Name)
+	fnlog.Infof("loading slice %q", slice)
+	if err := p.loadPodSlice(pod); err != nil {
+		fnlog.Errorf("failed to load slice %q: %s", slice, err)
+	}
+
+	// Only the app containers are started, they pull in the init containers they depend on. Units that couldn't be
+	// set up aren't started, and neither are the app containers when that is an init container's unit.
+	unitsToStart := []string{}
+	initWaiting := false
+	for _, u := range units {
+		// For logging purposes only.
+		init := ""
+		if u.init {
+			init = "init-"
+		}
+		fnlog.Infof("loading %sunit %q as %q", init, u.container, u.name)
+		fnlog.Debugf("unit %q:\n%s", u.name, u.uf)
+		if err := p.loadUnit(u.name, u.uf); err != nil {
+			fnlog.Errorf("failed to load unit %q: %s", u.name, err)
+		}
+		if w := unitWaiting(u.uf); w != nil {
+			fnlog.Warnf("not starting unit %q: %s", u.name, w.Message)
+			initWaiting = initWaiting || u.init
+			continue
+		}
+		if !u.init && !initWaiting {
+			unitsToStart = append(unitsToStart, u.name)
+		}
+	}
+	for _, name := range unitsToStart {
+		fnlog.Infof("starting unit %q", name)
+		if err := p.unitManager.TriggerStart(name); err != nil {
+			fnlog.Errorf("failed to trigger start for unit %q: %s", name, err)
+		}
+	}
+	p.postStartHooks(pod, unitsToStart)
+	p.prober.add(pod)
+	p.tokens.register(pod)
+	p.podResourceManager.Watch(pod)
+	return nil
+}
+
+// podUnit is a unit file generated for one of a Pod's containers.
+type podUnit struct {
+	name      string
+	container string
+	init      bool
+	uf        *unit.File
+}
+
+// podUnits installs the packages for and generates the unit files of all containers in pod, init containers first.
+// Generating the units is deterministic, so that UpdatePod can compare them against the loaded ones. If pull is true
+// packages with an image pull policy of Always are upgraded, see pullImage.
+func (p *p) podUnits(pod *corev1.Pod, pull bool) ([]podUnit, error) {
+	fnlog := log.
+		WithField("podNamespace", pod.Namespace).
+		WithField("podName", pod.Name)
 
 	vol, err := p.volumes(pod, volumeAll)
 	if err != nil {
 		err = errors.Wrap(err, "failed to process Pod volumes")
 		fnlog.Error(err)
-		return err
+		return nil, err
 	}
 
 	uid, gid, err := uidGidFromSecurityContext(pod, p.config.OverrideRootUID)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	etc, err := p.etcFiles(pod, uid, gid)
+	if err != nil {
+		err = errors.Wrap(err, "failed to write the Pod's resolv.conf and hosts")
+		fnlog.Error(err)
+		return nil, err
+	}
+
+	hostPaths := map[string]*corev1.HostPathVolumeSource{}
+	for _, v := range pod.Spec.Volumes {
+		if v.HostPath != nil {
+			hostPaths[v.Name] = v.HostPath
+		}
 	}
 
 	tmpfs := strings.Join([]string{"/var", "/run"}, " ")
+	qos := podQOS(pod)
 
-	unitsToStart := []string{}
+	units := []podUnit{}
 	previousUnit := ""
 	for i, c := range append(pod.Spec.InitContainers, pod.Spec.Containers...) {
 		isInit := i < len(pod.Spec.InitContainers)
 		fnlog.Debugf("processing container %d (init=%t)", i, isInit)
 
-		// TODO(miek) parse c.Image for tag to get version. Check ImagePullAlways to reinstall??
-		// if we're downloading the image, the image name needs cleaning
-		installed, err := p.pkgManager.Install(c.Image, "")
+		installed, version, err := p.pullImage(c, pull)
 		if err != nil {
 			err = errors.Wrapf(err, "failed to install package %q", c.Image)
 			fnlog.Error(err)
-			return err
+			return nil, err
+		}
+
+		// Like the kubelet, a container whose environment refers to a missing ConfigMap, Secret or key is waiting
+		// until it's there, see retryWaiting; the rest of the Pod is set up as usual.
+		waiting := []string{}
+		env, err := p.containerEnvironment(pod, c)
+		configErr := err != nil
+		if configErr {
+			fnlog.Warnf("failed to resolve the environment of %q: %s", c.Name, err)
+			waiting = append(waiting, err.Error())
 		}
 
 		bindmounts := []string{}
 		bindmountsro := []string{}
 		rwpaths := []string{}
-		for _, v := range c.VolumeMounts {
+		for j, v := range c.VolumeMounts {
 			dir, ok := vol[v.Name]
 			if !ok {
 				fnlog.Warnf("failed to find volumeMount %s in the specific volumes, skipping", v.Name)
 				continue
 			}
+			missing := false
+			if hp, ok := hostPaths[v.Name]; ok {
+				// The container can't run without its volume, but the path may be fixed later on. Record why in the
+				// unit and don't start it, see unitWaiting; the unit is complete otherwise, retryWaiting starts it
+				// once the path checks out.
+				if err := checkHostPath(hp, uid, gid); err != nil {
+					fnlog.Warnf("hostPath volume %s of %q: %s", v.Name, c.Name, err)
+					waiting = append(waiting, fmt.Sprintf("hostPath volume %s: %s", v.Name, err))
+					missing = true
+				}
+			}
+			sub := ""
+			if !configErr {
+				if sub, err = subPath(v, env); err != nil {
+					err = errors.Wrapf(err, "invalid subPath in volumeMount %s of %q", v.Name, c.Name)
+					fnlog.Error(err)
+					return nil, err
+				}
+			}
+			switch {
+			case configErr && (v.SubPath != "" || v.SubPathExpr != ""), sub != "" && missing:
+				dir = subPathStaging(pod, c, j) // staged once the container can be set up, see retryWaiting
+			case sub != "":
+				if dir, err = stageSubPath(pod, c, j, dir, sub, uid, gid); err != nil {
+					err = errors.Wrapf(err, "failed to resolve subPath in volumeMount %s of %q", v.Name, c.Name)
+					fnlog.Error(err)
+					return nil, err
+				}
+			}
 
 			if v.ReadOnly {
-				bindmountsro = append(bindmountsro, fmt.Sprintf("%s:%s", dir, v.MountPath)) // SubPath, look at todo, filepath.Join?
+				bindmountsro = append(bindmountsro, fmt.Sprintf("%s:%s", dir, v.MountPath))
 				continue
 			}
 			rwpaths = append(rwpaths, v.MountPath)
-			if dir == "" { // hostPath
-				continue
-			}
-			bindmounts = append(bindmounts, fmt.Sprintf("%s:%s", dir, v.MountPath)) // SubPath, look at todo, filepath.Join?
+			bindmounts = append(bindmounts, fmt.Sprintf("%s:%s", dir, v.MountPath))
 			// OK, so the v.MountPath will _exist_ on the system, as systemd will create it, permissions should not matter, as we
 			// only need this "hook" to mount the bindmount.
 		}
+		bindmountsro = append(bindmountsro, etcMounts(etc, c)...)
 
 		c.Image = ospkg.Clean(c.Image) // clean up the image if fetched with http(s)
+		pkg, _ := ospkg.ParseImage(c.Image)
 		name := podToUnitName(pod, c.Name)
 		if installed {
-			p.unitManager.Mask(c.Image + unit.ServiceSuffix)
+			p.unitManager.Mask(pkg + unit.ServiceSuffix)
 		}
 
-		uf, err := p.unitfileFromPackageOrSynthesized(c)
+		uf, err := p.unitfileFromPackageOrSynthesized(pkg)
 		if err != nil {
 			err = errors.Wrapf(err, "failed to process unit file for %q", c.Image)
 			fnlog.Error(err)
-			return err
+			return nil, err
 		}
 		if c.WorkingDir != "" {
 			uf = uf.Overwrite("Service", "WorkingDirectory", c.WorkingDir)
@@ -162,7 +285,7 @@ func (p *p) CreatePod(ctx context.Context, pod *corev1.Pod) error {
 			mapuid := strconv.FormatInt(int64(p.config.OverrideRootUID), 10)
 			u, err := user.LookupId(mapuid)
 			if err != nil {
-				return fmt.Errorf("root override UID %q, not found: %s", mapuid, err)
+				return nil, fmt.Errorf("root override UID %q, not found: %s", mapuid, err)
 			}
 			uid = u.Uid
 			gid = u.Gid
@@ -173,31 +296,57 @@ func (p *p) CreatePod(ctx context.Context, pod *corev1.Pod) error {
 			uf = uf.Overwrite("Service", "Group", gid)
 		}
 
-		// Treat initContainer differently.
-		if isInit {
-			uf = uf.Overwrite("Service", "Type", "oneshot") // no restarting
-			uf = uf.Insert(kubernetesSection, "InitContainer", "true")
-		}
+		uf = resourcesToUnit(uf, c.Resources)
+		uf = uf.Overwrite("Service", "Slice", podSlice(qos, pod.Namespace, pod.Name))
+		uf = uf.Overwrite("Service", "OOMScoreAdjust", strconv.Itoa(oomScoreAdjust(pod, c, qos, p.memoryCapacity)))
 
-		// Handle unit dependencies.
+		// Handle unit dependencies: each init container requires the one before it to have completed and the app
+		// containers require the last one, so they only start once all init containers succeeded.
 		if previousUnit != "" {
+			uf = uf.Insert("Unit", "Requires", previousUnit)
 			uf = uf.Insert("Unit", "After", previousUnit)
 		}
 
-		// keep the unit around, until DeletePod is triggered.
-		// this is also for us to return the state even after the unit left the stage.
-		uf = uf.Overwrite("Service", "RemainAfterExit", "true")
+		if isInit {
+			uf = initContainerToUnit(uf, pod.Spec.RestartPolicy)
+		} else {
+			uf = restartPolicyToUnit(uf, pod.Spec.RestartPolicy)
+			uf = lifecycleToUnit(uf, c, pod.Spec.TerminationGracePeriodSeconds)
+		}
+
+		c.Command = expandCommand(c.Command, env)
+		c.Args = expandCommand(c.Args, env)
 
 		execStart := commandAndArgs(uf, c)
 		if len(execStart) > 0 {
 			uf = uf.Overwrite("Service", "ExecStart", strings.Join(execStart, " "))
 		}
 
+		if restartedAt, ok := pod.Annotations[restartedAtAnnotation]; ok {
+			uf = uf.Insert(kubernetesSection, "RestartedAt", restartedAt)
+		}
+
 		id := string(pod.ObjectMeta.UID) // give multiple containers the same access? Need to test this.
 		uf = uf.Insert(kubernetesSection, "Namespace", pod.ObjectMeta.Namespace)
 		uf = uf.Insert(kubernetesSection, "ClusterName", pod.ObjectMeta.ClusterName)
 		uf = uf.Insert(kubernetesSection, "Id", id)
+		uf = uf.Insert(kubernetesSection, "RestartPolicy", string(pod.Spec.RestartPolicy))
+		uf = uf.Insert(kubernetesSection, "QOSClass", string(qos))
 		uf = uf.Insert(kubernetesSection, "Image", c.Image) // save (cleaned) image name here, we're not tracking this in the unit's name.
+		if version != "" {
+			uf = uf.Insert(kubernetesSection, "ImageID", pkg+"="+version)
+		}
+		for _, port := range c.Ports {
+			uf = uf.Insert(kubernetesSection, "Port", portToUnit(port))
+		}
+		if len(waiting) > 0 {
+			reason := createContainerError
+			if configErr {
+				reason = createContainerConfigError
+			}
+			uf = uf.Insert(kubernetesSection, "WaitingReason", reason)
+			uf = uf.Insert(kubernetesSection, "WaitingMessage", strings.Join(waiting, "; "))
+		}
 
 		uf = uf.Insert("Service", "TemporaryFileSystem", tmpfs)
 		if len(rwpaths) > 0 {
@@ -213,54 +362,57 @@ func (p *p) CreatePod(ctx context.Context, pod *corev1.Pod) error {
 			uf = uf.Insert("Service", "BindReadOnlyPaths", romount)
 		}
 
+		if uf, err = p.consoles.open(pod, c, name, uf); err != nil {
+			err = errors.Wrapf(err, "failed to set up stdin/tty for %q", c.Name)
+			fnlog.Error(err)
+			return nil, err
+		}
+
 		for _, del := range deleteOptions {
 			uf = uf.Delete("Service", del)
 		}
 
-		envVars := p.defaultEnvironment()
-		for _, env := range c.Env {
-			// If environment variable is a string with spaces, it must be quoted.
-			// Quoting seems innocuous to other strings so it's set by default.
-			envVars = append(envVars, fmt.Sprintf("%s=%q", env.Name, env.Value))
-		}
-		for _, env := range envVars {
+		for _, env := range p.defaultEnvironment() {
 			uf = uf.Insert("Service", "Environment", env)
 		}
-
-		// For logging purposes only.
-		init := ""
-		if isInit {
-			init = "init-"
-		}
-		fnlog.Infof("loading %sunit %q, %q as %q\n%s", init, c.Name, c.Image, name, uf)
-		if err := p.unitManager.Load(name, *uf); err != nil {
-			fnlog.Errorf("failed to load unit %q: %s", name, err)
+		// The container's own environment goes into a file, as it may hold Secrets. It's read after Environment, so
+		// it overrides the defaults.
+		if len(env) > 0 {
+			envFile, err := writeEnvironmentFile(pod, c, env)
+			if err != nil {
+				err = errors.Wrapf(err, "failed to write the environment of %q", c.Name)
+				fnlog.Error(err)
+				return nil, err
+			}
+			uf = uf.Insert("Service", "EnvironmentFile", envFile)
 		}
-		unitsToStart = append(unitsToStart, name)
+
+		units = append(units, podUnit{name: name, container: c.Name, init: isInit, uf: uf})
 		if isInit {
 			previousUnit = name
 		}
 	}
-	for _, name := range unitsToStart {
-		fnlog.Infof("starting unit %q", name)
-		if err := p.unitManager.TriggerStart(name); err != nil {
-			fnlog.Errorf("failed to trigger start for unit %q: %s", name, err)
-		}
-	}
-	p.podResourceManager.Watch(pod)
-	return nil
+	return units, nil
 }
 
 // RunInContainer executes a command in a container in the pod, copying data
 // between in/out/err and the container's stdin/stdout/stderr.
+// The command runs in the unit's execution context, see unitCommand.
 func (p *p) RunInContainer(ctx context.Context, namespace, name, container string, cmd []string, attach nodeapi.AttachIO) error {
-	log.
+	fnlog := log.
 		WithField("podNamespace", namespace).
 		WithField("podName", name).
-		WithField("containerName", container).
-		Debug("RunInContainer called")
+		WithField("containerName", container)
+
+	fnlog.Debug("RunInContainer called")
 
-	// Should we just try to start something? But with what user???
+	unitName := unitPrefix(namespace, name) + separator + container + unit.ServiceSuffix
+	if err := p.execInUnit(ctx, unitName, cmd, attach); err != nil {
+		if _, ok := err.(utilexec.ExitError); !ok {
+			fnlog.Errorf("failed to run %v in unit %q: %s", cmd, unitName, err)
+		}
+		return err
+	}
 	return nil
 }
 
@@ -317,14 +469,7 @@ func JournalReader(namespace, name, container string, logOpts nodeapi.ContainerL
 	// By default, timestamps are present in journal entries.
 	// Kubernetes defaults to not having timestamps, so we adapt.
 	if !logOpts.Timestamps {
-		journalConfig.Formatter = func(entry *sdjournal.JournalEntry) (string, error) {
-			msg, ok := entry.Fields[sdjournal.SD_JOURNAL_FIELD_MESSAGE]
-			if !ok {
-				return "", fmt.Errorf("no %q field present in journal entry", sdjournal.SD_JOURNAL_FIELD_MESSAGE)
-			}
-
-			return fmt.Sprintf("%s\n", msg), nil
-		}
+		journalConfig.Formatter = journalMessage
 	}
 
 	journalReader, err := sdjournal.NewJournalReader(journalConfig)
@@ -335,13 +480,92 @@ func JournalReader(namespace, name, container string, logOpts nodeapi.ContainerL
 	return journalReader, err
 }
 
-// UpdatePod is a noop,
+// journalMessage formats a journal entry as just its message.
+func journalMessage(entry *sdjournal.JournalEntry) (string, error) {
+	msg, ok := entry.Fields[sdjournal.SD_JOURNAL_FIELD_MESSAGE]
+	if !ok {
+		return "", fmt.Errorf("no %q field present in journal entry", sdjournal.SD_JOURNAL_FIELD_MESSAGE)
+	}
+
+	return fmt.Sprintf("%s\n", msg), nil
+}
+
+// UpdatePod regenerates the units of pod and compares them with the ones loaded. Changed units are rewritten and,
+// after a daemon-reload, restarted; units that are missing are loaded and started. As the units also carry the
+// restartedAt annotation, changing it restarts all of the Pod's containers.
 func (p *p) UpdatePod(ctx context.Context, pod *corev1.Pod) error {
-	log.
+	fnlog := log.
+		WithField("podNamespace", pod.Namespace).
+		WithField("podName", pod.Name)
+
+	fnlog.Debug("UpdatePod called")
+	defer p.locks.lock(pod.UID)()
+	return p.updatePod(ctx, pod)
+}
+
+// updatePod is UpdatePod for a Pod that is locked.
+func (p *p) updatePod(ctx context.Context, pod *corev1.Pod) error {
+	fnlog := log.
 		WithField("podNamespace", pod.Namespace).
-		WithField("podName", pod.Name).
-		Debug("UpdatePod called (no-op)")
+		WithField("podName", pod.Name)
+
+	states, err := p.unitStates(unitPrefix(pod.Namespace, pod.Name) + separator)
+	if err != nil {
+		return err
+	}
+	units, err := p.podUnits(pod, false)
+	if err != nil {
+		return err
+	}
+	writePod(pod)
+
+	changed := []string{}
+	files := map[string]*unit.File{}
+	for _, u := range units {
+		files[u.name] = u.uf
+		if s, ok := states[u.name]; ok && s.UnitData == u.uf.String() {
+			continue
+		}
+		fnlog.Infof("reloading unit %q", u.name)
+		fnlog.Debugf("unit %q:\n%s", u.name, u.uf)
+		if err := p.loadUnit(u.name, u.uf); err != nil {
+			fnlog.Errorf("failed to load unit %q: %s", u.name, err)
+			continue
+		}
+		changed = append(changed, u.name)
+	}
+	if len(changed) > 0 {
+		if err := p.loadPodSlice(pod); err != nil {
+			fnlog.Errorf("failed to load slice %q: %s", podSlice(podQOS(pod), pod.Namespace, pod.Name), err)
+		}
+		if err := p.unitManager.Reload(); err != nil {
+			fnlog.Errorf("failed to reload systemd: %s", err)
+		}
+	}
+	for _, name := range changed {
+		if w := unitWaiting(files[name]); w != nil {
+			fnlog.Warnf("stopping unit %q: %s", name, w.Message)
+			if err := p.unitManager.TriggerStop(name); err != nil {
+				fnlog.Errorf("failed to trigger stop for unit %q: %s", name, err)
+			}
+			continue
+		}
+		if _, ok := states[name]; !ok {
+			fnlog.Infof("starting unit %q", name)
+			if err := p.unitManager.TriggerStart(name); err != nil {
+				fnlog.Errorf("failed to trigger start for unit %q: %s", name, err)
+			}
+			continue
+		}
+		fnlog.Infof("restarting unit %q", name)
+		if err := p.unitManager.TriggerRestart(name); err != nil {
+			fnlog.Errorf("failed to trigger restart for unit %q: %s", name, err)
+		}
+	}
+	p.postStartHooks(pod, changed)
 
+	p.prober.add(pod)
+	p.tokens.register(pod)
 	return nil
 }
 
@@ -352,30 +576,64 @@ func (p *p) DeletePod(ctx context.Context, pod *corev1.Pod) error {
 		WithField("podName", pod.Name)
 
 	fnlog.Info("DeletePod called")
+	defer p.locks.lock(pod.UID)()
+
+	// The preStop hooks and stopping the units share the grace period, the units get at least minStopGracePeriod.
+	grace := gracePeriod(pod.Spec.TerminationGracePeriodSeconds)
+	deadline := time.Now().Add(grace)
+	p.prober.remove(pod)
+	p.preStopHooks(ctx, pod, grace)
+	if time.Until(deadline) < minStopGracePeriod {
+		deadline = time.Now().Add(minStopGracePeriod)
+	}
+
+	// Stopping the slice stops all of the Pod's units in one go. Pods created before systemk used slices don't have
+	// one, their units are stopped one by one.
+	slice := podSlice(podQOS(pod), pod.Namespace, pod.Name)
+	sliceErr := p.unitManager.TriggerStop(slice)
+	if sliceErr != nil {
+		fnlog.Warnf("failed to trigger stop for slice %q: %s", slice, sliceErr)
+	}
 
 	unitsToUnload := []string{}
 	for _, c := range append(pod.Spec.InitContainers, pod.Spec.Containers...) {
 		name := podToUnitName(pod, c.Name)
-		if err := p.unitManager.TriggerStop(name); err != nil {
-			fnlog.Warnf("failed to trigger stop for unit %q: %s", name, err)
+		if sliceErr != nil {
+			if err := p.unitManager.TriggerStop(name); err != nil {
+				fnlog.Warnf("failed to trigger stop for unit %q: %s", name, err)
+			}
 		}
 		unitsToUnload = append(unitsToUnload, name)
 	}
 
+	// The units need their volumes until they've stopped, e.g. to flush data to an emptyDir.
+	p.waitStopped(ctx, unitsToUnload, deadline)
+
 	for _, name := range unitsToUnload {
+		p.consoles.close(name)
 		if err := p.unitManager.Unload(name); err != nil {
 			fnlog.Warnf("failed to unload unit %q: %s", name, err)
 		}
+		p.cache.remove(name)
 		fnlog.Infof("deleted unit %q successfully", name)
 	}
+	if err := p.unitManager.Unload(slice); err != nil {
+		fnlog.Warnf("failed to unload slice %q: %s", slice, err)
+	}
+	p.cache.remove(slice)
 	p.unitManager.Reload()
 	p.podResourceManager.Unwatch(pod)
 
+	p.conditions.remove(pod.UID)
+	p.tokens.forget(pod)
+
 	// Clean-up volumes.
 	if err := cleanPodEphemeralVolumes(string(pod.UID)); err != nil {
 		fnlog.Warn("failed to clean-up volumes: %s", err)
 	}
 
+	p.notifier.deleted(pod)
+
 	return nil
 }
 
@@ -435,6 +693,9 @@ func Namespace(name string) string {
 }
 
 const (
+	// restartedAtAnnotation is set by kubectl rollout restart.
+	restartedAtAnnotation = "kubectl.kubernetes.io/restartedAt"
+
 	// prefix the unit file prefix we used.
 	prefix    = "systemk"
 	separator = "."
@@ -1,6 +1,15 @@
 package provider
 
-import "testing"
+import (
+	"context"
+	"testing"
+
+	"github.com/virtual-kubelet/systemk/internal/kubernetes"
+	"github.com/virtual-kubelet/systemk/internal/ospkg"
+	"github.com/virtual-kubelet/systemk/internal/unit"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/informers"
+)
 
 func TestNameSplitting(t *testing.T) {
 	name := "systemk.default.openssh-server.openssh-server-container.service"
@@ -17,3 +26,68 @@ func TestNameSplitting(t *testing.T) {
 		t.Errorf("expected Namespace to be %s, got %s", "default", x)
 	}
 }
+
+// restartRecorder records the units that are (re)started.
+type restartRecorder struct {
+	unit.Manager
+	started, restarted []string
+}
+
+func (r *restartRecorder) TriggerStart(name string) error {
+	r.started = append(r.started, name)
+	return nil
+}
+
+func (r *restartRecorder) TriggerRestart(name string) error {
+	r.restarted = append(r.restarted, name)
+	return nil
+}
+
+func TestUpdatePod(t *testing.T) {
+	log = &noopLogger{}
+	p := new(p)
+	p.pkgManager = &ospkg.NoopManager{}
+	mock, _ := unit.NewMockManager()
+	rec := &restartRecorder{Manager: mock}
+	p.unitManager = rec
+	p.config = &Opts{NodeName: "localhost"}
+	p.podResourceManager = kubernetes.NewPodResourceWatcher(informers.NewSharedInformerFactory(nil, 0))
+
+	pod := &corev1.Pod{}
+	pod.Namespace, pod.Name, pod.UID = "default", "update", "aa-bb"
+	pod.Spec.Containers = []corev1.Container{{Name: "a", Image: "bash"}, {Name: "b", Image: "bash"}}
+	if err := p.CreatePod(context.TODO(), pod); err != nil {
+		t.Fatal(err)
+	}
+	if len(rec.started) != 2 {
+		t.Fatalf("expected 2 units to be started, got %d", len(rec.started))
+	}
+
+	// Nothing changed.
+	rec.started, rec.restarted = nil, nil
+	if err := p.UpdatePod(context.TODO(), pod); err != nil {
+		t.Fatal(err)
+	}
+	if len(rec.started)+len(rec.restarted) != 0 {
+		t.Errorf("expected no units to be (re)started, got %v and %v", rec.started, rec.restarted)
+	}
+
+	// Changing one container only restarts that one.
+	pod.Spec.Containers[1].Env = []corev1.EnvVar{{Name: "X", Value: "y"}}
+	if err := p.UpdatePod(context.TODO(), pod); err != nil {
+		t.Fatal(err)
+	}
+	if len(rec.restarted) != 1 || rec.restarted[0] != podToUnitName(pod, "b") {
+		t.Errorf("expected only unit of container b to be restarted, got %v", rec.restarted)
+	}
+
+	// The restartedAt annotation restarts all of them.
+	rec.restarted = nil
+	pod.Annotations = map[string]string{restartedAtAnnotation: "2021-06-01T00:00:00Z"}
+	if err := p.UpdatePod(context.TODO(), pod); err != nil {
+		t.Fatal(err)
+	}
+	if len(rec.restarted) != 2 {
+		t.Errorf("expected 2 units to be restarted, got %v", rec.restarted)
+	}
+}
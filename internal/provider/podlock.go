@@ -0,0 +1,45 @@
+package provider
+
+import (
+	"sync"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// podLocks serializes the work on a Pod: CreatePod, UpdatePod and DeletePod, and what systemk does on its own in the
+// background, see checkWaiting. The zero value is ready to use.
+type podLocks struct {
+	mu sync.Mutex
+	m  map[types.UID]*podLock
+}
+
+type podLock struct {
+	mu   sync.Mutex
+	refs int // guarded by podLocks.mu
+}
+
+// lock locks the Pod with uid and returns the function that unlocks it.
+func (l *podLocks) lock(uid types.UID) func() {
+	l.mu.Lock()
+	if l.m == nil {
+		l.m = make(map[types.UID]*podLock)
+	}
+	pl, ok := l.m[uid]
+	if !ok {
+		pl = &podLock{}
+		l.m[uid] = pl
+	}
+	pl.refs++
+	l.mu.Unlock()
+
+	pl.mu.Lock()
+	return func() {
+		pl.mu.Unlock()
+		l.mu.Lock()
+		pl.refs--
+		if pl.refs == 0 {
+			delete(l.m, uid)
+		}
+		l.mu.Unlock()
+	}
+}
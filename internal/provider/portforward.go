@@ -0,0 +1,356 @@
+package provider
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/virtual-kubelet/systemk/internal/unit"
+	"github.com/virtual-kubelet/virtual-kubelet/errdefs"
+	"golang.org/x/net/websocket"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/apimachinery/pkg/util/httpstream/spdy"
+	"k8s.io/client-go/tools/portforward"
+)
+
+// PortForwardHandler handles kubectl port-forward. As Pods share the host's network each forwarded stream is spliced
+// into a TCP connection to the requested port on the node's loopback. Only ports that are a containerPort of the
+// Pod can be forwarded. Both the SPDY and the websocket protocols are supported.
+func (p *p) PortForwardHandler(w http.ResponseWriter, r *http.Request) {
+	handleError(func(w http.ResponseWriter, req *http.Request) error {
+		vars := mux.Vars(req)
+		namespace := vars["namespace"]
+		name := vars["pod"]
+
+		pod, err := p.GetPod(req.Context(), namespace, name)
+		if err != nil {
+			return err
+		}
+		if pod == nil {
+			return errdefs.NotFoundf("pod %s/%s not found", namespace, name)
+		}
+		pf := &portForwarder{ports: containerPorts(pod), idle: p.config.StreamIdleTimeout, creation: p.config.StreamCreationTimeout}
+
+		if isWebSocketRequest(req) {
+			return pf.serveWebSocket(w, req)
+		}
+		return pf.serveSPDY(w, req)
+	})(w, r)
+}
+
+// containerPorts returns the TCP container ports of all containers in pod.
+func containerPorts(pod *corev1.Pod) map[int32]bool {
+	ports := map[int32]bool{}
+	for _, c := range append(pod.Spec.InitContainers, pod.Spec.Containers...) {
+		for _, port := range c.Ports {
+			if port.Protocol == "" || port.Protocol == corev1.ProtocolTCP {
+				ports[port.ContainerPort] = true
+			}
+		}
+	}
+	return ports
+}
+
+type portForwarder struct {
+	ports    map[int32]bool
+	idle     time.Duration
+	creation time.Duration
+}
+
+// forward splices stream into a connection to port on the loopback.
+func (pf *portForwarder) forward(port int32, stream io.ReadWriteCloser) error {
+	if !pf.ports[port] {
+		return fmt.Errorf("port %d is not a container port of the pod", port)
+	}
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(int(port))))
+	if err != nil {
+		return fmt.Errorf("failed to connect to port %d: %s", port, err)
+	}
+	defer conn.Close()
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(conn, stream)
+		if tc, ok := conn.(*net.TCPConn); ok {
+			tc.CloseWrite()
+		}
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(stream, conn)
+		done <- struct{}{}
+	}()
+	<-done
+	return nil
+}
+
+// serveSPDY handles the SPDY protocol. Each forwarded connection consists of an error and a data stream, tied
+// together by the requestID header.
+func (pf *portForwarder) serveSPDY(w http.ResponseWriter, req *http.Request) error {
+	if _, err := httpstream.Handshake(req, w, []string{portforward.PortForwardProtocolV1Name}); err != nil {
+		return errdefs.AsInvalidInput(err)
+	}
+
+	streams := make(chan httpstream.Stream, 4)
+	upgrader := spdy.NewResponseUpgrader()
+	conn := upgrader.UpgradeResponse(w, req, func(stream httpstream.Stream, replySent <-chan struct{}) error {
+		if stream.Headers().Get(corev1.PortForwardRequestIDHeader) == "" {
+			return fmt.Errorf("%q header is required", corev1.PortForwardRequestIDHeader)
+		}
+		streams <- stream
+		return nil
+	})
+	if conn == nil {
+		// The upgrader has written the error to the client.
+		return nil
+	}
+	defer conn.Close()
+	conn.SetIdleTimeout(pf.idle)
+
+	type pair struct {
+		data, err httpstream.Stream
+		created   time.Time
+	}
+	pairs := map[string]*pair{}
+	cleanup := time.NewTicker(pf.creation)
+	defer cleanup.Stop()
+
+	for {
+		select {
+		case <-conn.CloseChan():
+			return nil
+
+		case <-cleanup.C:
+			// Streams that didn't find their partner in time are reset.
+			for id, pr := range pairs {
+				if time.Since(pr.created) < pf.creation {
+					continue
+				}
+				for _, s := range []httpstream.Stream{pr.data, pr.err} {
+					if s != nil {
+						s.Reset()
+					}
+				}
+				delete(pairs, id)
+			}
+
+		case stream := <-streams:
+			id := stream.Headers().Get(corev1.PortForwardRequestIDHeader)
+			pr, ok := pairs[id]
+			if !ok {
+				pr = &pair{created: time.Now()}
+				pairs[id] = pr
+			}
+			switch stream.Headers().Get(corev1.StreamType) {
+			case corev1.StreamTypeData:
+				pr.data = stream
+			case corev1.StreamTypeError:
+				pr.err = stream
+			default:
+				stream.Reset()
+				continue
+			}
+			if pr.data == nil || pr.err == nil {
+				continue
+			}
+			delete(pairs, id)
+
+			go func(pr *pair) {
+				defer pr.data.Close()
+				defer pr.err.Close()
+				port, err := strconv.ParseUint(pr.data.Headers().Get(corev1.PortHeader), 10, 16)
+				if err == nil {
+					err = pf.forward(int32(port), pr.data)
+				}
+				if err != nil {
+					log.Warnf("port-forward failed: %s", err)
+					io.WriteString(pr.err, err.Error())
+				}
+			}(pr)
+		}
+	}
+}
+
+const (
+	binaryChannelProtocol = "v4.channel.k8s.io"
+	base64ChannelProtocol = "v4.base64.channel.k8s.io"
+)
+
+func isWebSocketRequest(req *http.Request) bool {
+	return httpstream.IsUpgradeRequest(req) && strings.EqualFold(req.Header.Get("Upgrade"), "websocket")
+}
+
+// serveWebSocket handles the websocket protocol. The ports are given as query parameters, each port gets a data and an
+// error channel (2*i and 2*i+1). Every message is prefixed with the channel number and the first message on a channel
+// is the port number, as a little endian uint16.
+func (pf *portForwarder) serveWebSocket(w http.ResponseWriter, req *http.Request) error {
+	var ports []int32
+	for _, s := range req.URL.Query()["port"] {
+		port, err := strconv.ParseUint(s, 10, 16)
+		if err != nil {
+			return errdefs.InvalidInputf("invalid port %q", s)
+		}
+		ports = append(ports, int32(port))
+	}
+	if len(ports) == 0 {
+		return errdefs.InvalidInput("at least one port must be given")
+	}
+
+	protocol := ""
+	srv := websocket.Server{
+		Handshake: func(config *websocket.Config, req *http.Request) error {
+			for _, p := range config.Protocol {
+				if p == binaryChannelProtocol || p == base64ChannelProtocol {
+					protocol = p
+					config.Protocol = []string{p}
+					return nil
+				}
+			}
+			config.Protocol = nil // binary, without channel protocol negotiation
+			return nil
+		},
+		Handler: func(ws *websocket.Conn) {
+			defer ws.Close()
+			wc := &wsChannels{ws: ws, base64: protocol == base64ChannelProtocol, in: map[byte]*io.PipeWriter{}}
+
+			var wg sync.WaitGroup
+			for i, port := range ports {
+				data := wc.channel(byte(2*i), port)
+				errc := wc.channel(byte(2*i+1), port)
+				wg.Add(1)
+				go func(port int32) {
+					defer wg.Done()
+					if err := pf.forward(port, data); err != nil {
+						log.Warnf("port-forward failed: %s", err)
+						io.WriteString(errc, err.Error())
+					}
+				}(port)
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			go func() {
+				wg.Wait()
+				cancel()
+				ws.Close()
+			}()
+			wc.read(ctx, pf.idle)
+		},
+	}
+	srv.ServeHTTP(w, req)
+	return nil
+}
+
+// wsChannels multiplexes channels over a websocket connection.
+type wsChannels struct {
+	ws     *websocket.Conn
+	base64 bool
+
+	mu sync.Mutex // protects writes to ws and in
+	in map[byte]*io.PipeWriter
+}
+
+type wsChannel struct {
+	wc *wsChannels
+	id byte
+	r  *io.PipeReader
+}
+
+// channel opens channel id and announces port on it.
+func (wc *wsChannels) channel(id byte, port int32) *wsChannel {
+	r, w := io.Pipe()
+	wc.mu.Lock()
+	wc.in[id] = w
+	wc.mu.Unlock()
+	c := &wsChannel{wc: wc, id: id, r: r}
+	buf := make([]byte, 2)
+	binary.LittleEndian.PutUint16(buf, uint16(port))
+	c.Write(buf)
+	return c
+}
+
+// read reads the messages from the websocket and hands them to the channels.
+func (wc *wsChannels) read(ctx context.Context, idle time.Duration) {
+	defer func() {
+		wc.mu.Lock()
+		for _, w := range wc.in {
+			w.Close()
+		}
+		wc.mu.Unlock()
+	}()
+	for ctx.Err() == nil {
+		if idle > 0 {
+			wc.ws.SetReadDeadline(time.Now().Add(idle))
+		}
+		var msg []byte
+		if err := websocket.Message.Receive(wc.ws, &msg); err != nil {
+			return
+		}
+		if len(msg) == 0 {
+			continue
+		}
+		id, data := msg[0], msg[1:]
+		if wc.base64 {
+			id -= '0'
+			buf, err := base64.StdEncoding.DecodeString(string(data))
+			if err != nil {
+				continue
+			}
+			data = buf
+		}
+		wc.mu.Lock()
+		w, ok := wc.in[id]
+		wc.mu.Unlock()
+		if ok {
+			w.Write(data)
+		}
+	}
+}
+
+func (c *wsChannel) Read(p []byte) (int, error) { return c.r.Read(p) }
+
+func (c *wsChannel) Write(p []byte) (int, error) {
+	c.wc.mu.Lock()
+	defer c.wc.mu.Unlock()
+	if c.wc.base64 {
+		msg := string('0'+c.id) + base64.StdEncoding.EncodeToString(p)
+		return len(p), websocket.Message.Send(c.wc.ws, msg)
+	}
+	return len(p), websocket.Message.Send(c.wc.ws, append([]byte{c.id}, p...))
+}
+
+func (c *wsChannel) Close() error { return c.r.Close() }
+
+// portToUnit returns the value of the Port key in the unit's kubernetesSection: <containerPort>/<protocol>.
+func portToUnit(port corev1.ContainerPort) string {
+	protocol := port.Protocol
+	if protocol == "" {
+		protocol = corev1.ProtocolTCP
+	}
+	return fmt.Sprintf("%d/%s", port.ContainerPort, protocol)
+}
+
+// unitToPorts returns the container ports recorded in the unit.
+func unitToPorts(uf *unit.File) []corev1.ContainerPort {
+	var ports []corev1.ContainerPort
+	for _, v := range uf.Contents[kubernetesSection]["Port"] {
+		i := strings.Index(v, "/")
+		if i < 0 {
+			continue
+		}
+		port, err := strconv.ParseInt(v[:i], 10, 32)
+		if err != nil {
+			continue
+		}
+		ports = append(ports, corev1.ContainerPort{ContainerPort: int32(port), Protocol: corev1.Protocol(v[i+1:])})
+	}
+	return ports
+}
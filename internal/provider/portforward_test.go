@@ -0,0 +1,65 @@
+package provider
+
+import (
+	"io"
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/virtual-kubelet/systemk/internal/unit"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestPortsRoundTrip(t *testing.T) {
+	ports := []corev1.ContainerPort{{ContainerPort: 80}, {ContainerPort: 53, Protocol: corev1.ProtocolUDP}}
+	uf := &unit.File{}
+	for _, port := range ports {
+		uf = uf.Insert(kubernetesSection, "Port", portToUnit(port))
+	}
+	got := unitToPorts(uf)
+	if len(got) != 2 {
+		t.Fatalf("expected 2 ports, got %d", len(got))
+	}
+	if got[0].ContainerPort != 80 || got[0].Protocol != corev1.ProtocolTCP {
+		t.Errorf("expected 80/TCP, got %d/%s", got[0].ContainerPort, got[0].Protocol)
+	}
+	if got[1].ContainerPort != 53 || got[1].Protocol != corev1.ProtocolUDP {
+		t.Errorf("expected 53/UDP, got %d/%s", got[1].ContainerPort, got[1].Protocol)
+	}
+}
+
+func TestPortForward(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		conn.Write([]byte("hello"))
+		conn.Close()
+	}()
+	_, p, _ := net.SplitHostPort(l.Addr().String())
+	port, _ := strconv.Atoi(p)
+
+	pf := &portForwarder{ports: map[int32]bool{int32(port): true}}
+	if err := pf.forward(int32(port+1), nil); err == nil {
+		t.Errorf("expected error for port that isn't a container port")
+	}
+
+	client, server := net.Pipe()
+	done := make(chan error)
+	go func() { done <- pf.forward(int32(port), server) }()
+	buf := make([]byte, 5)
+	io.ReadFull(client, buf)
+	if string(buf) != "hello" {
+		t.Errorf("expected %q, got %q", "hello", buf)
+	}
+	client.Close()
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+}
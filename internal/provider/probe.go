@@ -0,0 +1,380 @@
+package provider
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// prober runs the liveness, readiness and startup probes for the containers of all pods. There is a worker per
+// container (i.e. unit) that has at least one probe defined.
+type prober struct {
+	ctx context.Context
+	p   *p
+
+	mu      sync.RWMutex
+	workers map[string]*probeWorker // keyed by unit name
+}
+
+func newProber(ctx context.Context, p *p) *prober {
+	return &prober{ctx: ctx, p: p, workers: make(map[string]*probeWorker)}
+}
+
+// add starts the probe workers for the containers in pod, containers that already have a worker are skipped.
+func (pr *prober) add(pod *corev1.Pod) {
+	if pr == nil {
+		return
+	}
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	for _, c := range pod.Spec.Containers {
+		if c.LivenessProbe == nil && c.ReadinessProbe == nil && c.StartupProbe == nil {
+			continue
+		}
+		name := podToUnitName(pod, c.Name)
+		if _, ok := pr.workers[name]; ok {
+			continue
+		}
+		ctx, cancel := context.WithCancel(pr.ctx)
+		w := &probeWorker{
+			p:         pr.p,
+			unit:      name,
+			container: *c.DeepCopy(),
+			cancel:    cancel,
+			startup:   newProbeState(c.StartupProbe),
+			liveness:  newProbeState(c.LivenessProbe),
+			readiness: newProbeState(c.ReadinessProbe),
+		}
+		pr.workers[name] = w
+		go w.run(ctx)
+	}
+}
+
+// remove stops the probe workers for the containers in pod.
+func (pr *prober) remove(pod *corev1.Pod) {
+	if pr == nil {
+		return
+	}
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	for _, c := range pod.Spec.Containers {
+		name := podToUnitName(pod, c.Name)
+		if w, ok := pr.workers[name]; ok {
+			w.cancel()
+			delete(pr.workers, name)
+		}
+	}
+}
+
+// status returns the readiness and started state for the unit name. If the unit has no probes, both follow running.
+func (pr *prober) status(name string, running bool) (ready, started bool) {
+	if pr == nil || !running {
+		return running, running
+	}
+	pr.mu.RLock()
+	w, ok := pr.workers[name]
+	pr.mu.RUnlock()
+	if !ok {
+		return running, running
+	}
+	return w.status()
+}
+
+// probeState tracks a single probe of a container.
+type probeState struct {
+	probe     *corev1.Probe
+	next      time.Time
+	successes int32
+	failures  int32
+}
+
+func newProbeState(probe *corev1.Probe) *probeState {
+	if probe == nil {
+		return nil
+	}
+	return &probeState{probe: probe}
+}
+
+func (s *probeState) reset(startedAt time.Time) {
+	s.next = startedAt.Add(time.Duration(s.probe.InitialDelaySeconds) * time.Second)
+	s.successes = 0
+	s.failures = 0
+}
+
+// due returns true if the probe should run now, if so the next run is scheduled.
+func (s *probeState) due(now time.Time) bool {
+	if now.Before(s.next) {
+		return false
+	}
+	s.next = now.Add(time.Duration(orDefault(s.probe.PeriodSeconds, 10)) * time.Second)
+	return true
+}
+
+// record records the result of the probe and returns true when a threshold has been crossed, ok is
+// then the new outcome of the probe.
+func (s *probeState) record(err error) (crossed, ok bool) {
+	if err != nil {
+		s.successes = 0
+		s.failures++
+		return s.failures == orDefault(s.probe.FailureThreshold, 3), false
+	}
+	s.failures = 0
+	s.successes++
+	return s.successes == orDefault(s.probe.SuccessThreshold, 1), true
+}
+
+// probeWorker periodically probes a single container.
+type probeWorker struct {
+	p         *p
+	unit      string
+	container corev1.Container
+	cancel    context.CancelFunc
+
+	startup   *probeState
+	liveness  *probeState
+	readiness *probeState
+
+	mu        sync.RWMutex
+	ready     bool
+	started   bool
+	startedAt time.Time // start time of the process the results belong to
+}
+
+func (w *probeWorker) status() (ready, started bool) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.ready, w.started
+}
+
+func (w *probeWorker) set(ready, started bool) {
+	w.mu.Lock()
+	changed := w.ready != ready || w.started != started
+	w.ready, w.started = ready, started
+	w.mu.Unlock()
+	if changed {
+		w.p.notifier.changed(w.unit)
+	}
+}
+
+func (w *probeWorker) run(ctx context.Context) {
+	tick := time.NewTicker(1 * time.Second)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+			w.probe(ctx)
+		}
+	}
+}
+
+// probe runs the probes that are due and updates the readiness and started state of the container.
+func (w *probeWorker) probe(ctx context.Context) {
+	fnlog := log.WithField("unit", w.unit)
+
+	startedAt, running := w.p.unitRunning(w.unit)
+	if !running {
+		w.set(false, false)
+		w.startedAt = time.Time{}
+		return
+	}
+
+	ready, started := w.status()
+	if !startedAt.Equal(w.startedAt) {
+		// A new process, start with a clean slate.
+		w.startedAt = startedAt
+		for _, s := range []*probeState{w.startup, w.liveness, w.readiness} {
+			if s != nil {
+				s.reset(startedAt)
+			}
+		}
+		ready, started = false, w.startup == nil
+	}
+
+	now := time.Now()
+	if !started {
+		if w.startup.due(now) {
+			err := w.p.runHandler(ctx, w.unit, w.container, w.startup.probe.Handler, timeout(w.startup.probe))
+			crossed, ok := w.startup.record(err)
+			switch {
+			case crossed && ok:
+				started = true
+			case crossed:
+				fnlog.Warnf("startup probe failed, restarting: %s", err)
+				w.restart()
+			}
+		}
+		if !started {
+			w.set(false, false)
+			return
+		}
+	}
+
+	if w.liveness != nil && w.liveness.due(now) {
+		err := w.p.runHandler(ctx, w.unit, w.container, w.liveness.probe.Handler, timeout(w.liveness.probe))
+		if crossed, ok := w.liveness.record(err); crossed && !ok {
+			fnlog.Warnf("liveness probe failed, restarting: %s", err)
+			w.set(false, started)
+			w.restart()
+			return
+		}
+	}
+
+	switch {
+	case w.readiness == nil:
+		ready = true
+	case w.readiness.due(now):
+		err := w.p.runHandler(ctx, w.unit, w.container, w.readiness.probe.Handler, timeout(w.readiness.probe))
+		if crossed, ok := w.readiness.record(err); crossed {
+			if !ok {
+				fnlog.Infof("readiness probe failed: %s", err)
+			}
+			ready = ok
+		}
+	}
+	w.set(ready, started)
+}
+
+// restart restarts the unit, as the kubelet would restart a container.
+func (w *probeWorker) restart() {
+	if err := w.p.unitManager.TriggerRestart(w.unit); err != nil {
+		log.Errorf("failed to trigger restart for unit %q: %s", w.unit, err)
+	}
+}
+
+// unitRunning returns true and the start time of the main process, if the unit name is running.
+func (p *p) unitRunning(name string) (time.Time, bool) {
+	s, err := p.unitState(name)
+	if err != nil || s.SubState != "running" {
+		return time.Time{}, false
+	}
+	return propertyTimestampToTime(p.serviceProperty(name, "ExecMainStartTimestamp")), true
+}
+
+// runHandler executes the handler h for container c, which runs as unit name. A nil error means success.
+func (p *p) runHandler(ctx context.Context, name string, c corev1.Container, h corev1.Handler, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	switch {
+	case h.Exec != nil:
+		cmd, err := p.unitCommand(ctx, name, h.Exec.Command)
+		if err != nil {
+			return err
+		}
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("%s: %s", err, out)
+		}
+		return nil
+
+	case h.HTTPGet != nil:
+		port, err := resolvePort(h.HTTPGet.Port, c)
+		if err != nil {
+			return err
+		}
+		scheme := strings.ToLower(string(h.HTTPGet.Scheme))
+		if scheme == "" {
+			scheme = "http"
+		}
+		u := &url.URL{
+			Scheme: scheme,
+			Host:   net.JoinHostPort(p.handlerHost(h.HTTPGet.Host), strconv.Itoa(port)),
+			Path:   h.HTTPGet.Path,
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+		if err != nil {
+			return err
+		}
+		for _, hdr := range h.HTTPGet.HTTPHeaders {
+			if hdr.Name == "Host" {
+				req.Host = hdr.Value
+				continue
+			}
+			req.Header.Add(hdr.Name, hdr.Value)
+		}
+		if req.Header.Get("User-Agent") == "" {
+			req.Header.Set("User-Agent", "systemk-probe")
+		}
+		resp, err := probeClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusBadRequest {
+			return fmt.Errorf("HTTP probe failed with statuscode: %d", resp.StatusCode)
+		}
+		return nil
+
+	case h.TCPSocket != nil:
+		port, err := resolvePort(h.TCPSocket.Port, c)
+		if err != nil {
+			return err
+		}
+		d := net.Dialer{}
+		conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(p.handlerHost(h.TCPSocket.Host), strconv.Itoa(port)))
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+
+	return fmt.Errorf("missing handler for container %q", c.Name)
+}
+
+// probeClient is the HTTP client used for probing, like the kubelet it doesn't verify certificates.
+var probeClient = &http.Client{
+	Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+		DisableKeepAlives: true,
+	},
+}
+
+// handlerHost returns host, or if empty the Pod's IP address.
+func (p *p) handlerHost(host string) string {
+	if host != "" {
+		return host
+	}
+	if p.config.NodeInternalIP == nil || p.config.NodeInternalIP.IsUnspecified() {
+		return "127.0.0.1"
+	}
+	return p.config.NodeInternalIP.String()
+}
+
+// resolvePort returns the port number for port, named ports are looked up in the container's ports.
+func resolvePort(port intstr.IntOrString, c corev1.Container) (int, error) {
+	if port.Type == intstr.Int {
+		return port.IntValue(), nil
+	}
+	for _, cp := range c.Ports {
+		if cp.Name == port.StrVal {
+			return int(cp.ContainerPort), nil
+		}
+	}
+	if n, err := strconv.Atoi(port.StrVal); err == nil {
+		return n, nil
+	}
+	return 0, fmt.Errorf("failed to find port %q in container %q", port.StrVal, c.Name)
+}
+
+func timeout(probe *corev1.Probe) time.Duration {
+	return time.Duration(orDefault(probe.TimeoutSeconds, 1)) * time.Second
+}
+
+// orDefault returns i, or def when i is not set.
+func orDefault(i, def int32) int32 {
+	if i <= 0 {
+		return def
+	}
+	return i
+}
@@ -0,0 +1,87 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func TestProbeStateThresholds(t *testing.T) {
+	s := newProbeState(&corev1.Probe{SuccessThreshold: 2, FailureThreshold: 2})
+	s.reset(time.Now())
+
+	if crossed, _ := s.record(nil); crossed {
+		t.Errorf("expected success threshold not to be crossed after 1 success")
+	}
+	if crossed, ok := s.record(nil); !crossed || !ok {
+		t.Errorf("expected success threshold to be crossed after 2 successes")
+	}
+	if crossed, _ := s.record(fmt.Errorf("fail")); crossed {
+		t.Errorf("expected failure threshold not to be crossed after 1 failure")
+	}
+	if crossed, ok := s.record(fmt.Errorf("fail")); !crossed || ok {
+		t.Errorf("expected failure threshold to be crossed after 2 failures")
+	}
+}
+
+func TestResolvePort(t *testing.T) {
+	c := corev1.Container{Name: "c", Ports: []corev1.ContainerPort{{Name: "http", ContainerPort: 8080}}}
+	tests := []struct {
+		port   intstr.IntOrString
+		expect int
+		err    bool
+	}{
+		{intstr.FromInt(80), 80, false},
+		{intstr.FromString("http"), 8080, false},
+		{intstr.FromString("9090"), 9090, false},
+		{intstr.FromString("metrics"), 0, true},
+	}
+	for i, tc := range tests {
+		port, err := resolvePort(tc.port, c)
+		if tc.err != (err != nil) {
+			t.Errorf("test %d, expected error to be %t, got %v", i, tc.err, err)
+		}
+		if port != tc.expect {
+			t.Errorf("test %d, expected port %d, got %d", i, tc.expect, port)
+		}
+	}
+}
+
+func TestRunHandler(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" {
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+	host, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+	portn, _ := strconv.Atoi(port)
+
+	p := new(p)
+	p.config = &Opts{}
+	c := corev1.Container{Name: "c"}
+	tests := []struct {
+		h   corev1.Handler
+		err bool
+	}{
+		{corev1.Handler{HTTPGet: &corev1.HTTPGetAction{Host: host, Port: intstr.FromInt(portn), Path: "/healthz"}}, false},
+		{corev1.Handler{HTTPGet: &corev1.HTTPGetAction{Host: host, Port: intstr.FromInt(portn), Path: "/fail"}}, true},
+		{corev1.Handler{TCPSocket: &corev1.TCPSocketAction{Host: host, Port: intstr.FromInt(portn)}}, false},
+		{corev1.Handler{}, true},
+	}
+	for i, tc := range tests {
+		err := p.runHandler(context.TODO(), "unit", c, tc.h, 1*time.Second)
+		if tc.err != (err != nil) {
+			t.Errorf("test %d, expected error to be %t, got %v", i, tc.err, err)
+		}
+	}
+}
@@ -13,6 +13,7 @@ import (
 	"github.com/virtual-kubelet/virtual-kubelet/node"
 	"github.com/virtual-kubelet/virtual-kubelet/node/api"
 	corev1 "k8s.io/api/core/v1"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
 )
 
 // log is the global logger for the provider.
@@ -25,6 +26,7 @@ var log = vklogv2.New(nil)
 // core logic to be able to understand the type of failure.
 type Provider interface {
 	node.PodLifecycleHandler
+	node.PodNotifier
 
 	kubernetes.ResourceUpdater
 
@@ -35,6 +37,12 @@ type Provider interface {
 	// between in/out/err and the container's stdin/stdout/stderr.
 	RunInContainer(ctx context.Context, namespace, podName, containerName string, cmd []string, attach api.AttachIO) error
 
+	// AttachToContainer attaches to the stdin/stdout/stderr of a running container in the pod.
+	AttachToContainer(ctx context.Context, namespace, podName, containerName string, cmd []string, attach api.AttachIO) error
+
+	// PortForwardHandler handles port forwarding to a Pod.
+	PortForwardHandler(w http.ResponseWriter, r *http.Request)
+
 	// ConfigureNode enables a provider to configure the Node object that
 	// will be used for Kubernetes.
 	ConfigureNode(context.Context, *Opts) (*corev1.Node, error)
@@ -51,6 +59,15 @@ type p struct {
 	config      *Opts
 	pkgManager  ospkg.Manager
 	unitManager unit.Manager
+	prober      *prober
+	consoles    *consoles
+	notifier    *notifier
+	cache       *unitCache
+	conditions  *conditions
+	tokens      *tokenManager
+	locks       podLocks
+
+	memoryCapacity int64 // in bytes, used for the OOM score adjustment of Burstable Pods
 
 	podResourceManager kubernetes.PodResourceManager
 	kubernetesURL      string // TODO(pires) pass this in Opts
@@ -63,7 +80,8 @@ const defaultUnitDir = "/var/run/systemk"
 
 // New returns a new systemd provider.
 // informerFactory is the basis for ConfigMap and Secret retrieval and event handling.
-func New(ctx context.Context, config *Opts, podWatcher kubernetes.PodResourceManager) (Provider, error) {
+// serviceAccounts is used to request the service account tokens of the Pods.
+func New(ctx context.Context, config *Opts, podWatcher kubernetes.PodResourceManager, serviceAccounts corev1client.ServiceAccountsGetter) (Provider, error) {
 	if err := os.MkdirAll(defaultUnitDir, 0750); err != nil {
 		return nil, err
 	}
@@ -76,6 +94,22 @@ func New(ctx context.Context, config *Opts, podWatcher kubernetes.PodResourceMan
 		config:             config,
 		podResourceManager: podWatcher,
 	}
+	p.prober = newProber(ctx, p)
+	p.consoles = newConsoles()
+	p.notifier = newNotifier(p)
+	p.conditions = newConditions()
+	p.tokens = newTokenManager(serviceAccounts)
+	memory := capacity()[corev1.ResourceMemory]
+	p.memoryCapacity = memory.Value()
+	p.cache = newUnitCache(unitManager, defaultUnitDir)
+	if err := p.cache.rebuild(); err != nil {
+		return nil, err
+	}
+	p.restorePods()
+	go p.cache.run(ctx, p.unitChanged)
+	go p.refreshTokens(ctx)
+	go p.monitorEmptyDirs(ctx)
+	go p.retryWaiting(ctx)
 
 	systemID := system.ID()
 	switch systemID {
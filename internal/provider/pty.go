@@ -0,0 +1,40 @@
+package provider
+
+import (
+	"os"
+	"strconv"
+
+	nodeapi "github.com/virtual-kubelet/virtual-kubelet/node/api"
+	"golang.org/x/sys/unix"
+)
+
+// openPty opens a new pseudo terminal and returns the master and the slave.
+func openPty() (master, slave *os.File, err error) {
+	master, err = os.OpenFile("/dev/ptmx", os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	fd := int(master.Fd())
+	// unlockpt(3)
+	if err := unix.IoctlSetPointerInt(fd, unix.TIOCSPTLCK, 0); err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	// ptsname(3)
+	n, err := unix.IoctlGetInt(fd, unix.TIOCGPTN)
+	if err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	slave, err = os.OpenFile("/dev/pts/"+strconv.Itoa(n), os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	return master, slave, nil
+}
+
+// resizePty sets the window size of the pseudo terminal pty.
+func resizePty(pty *os.File, size nodeapi.TermSize) error {
+	return unix.IoctlSetWinsize(int(pty.Fd()), unix.TIOCSWINSZ, &unix.Winsize{Row: size.Height, Col: size.Width})
+}
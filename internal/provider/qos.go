@@ -0,0 +1,132 @@
+package provider
+
+import (
+	"strings"
+
+	"github.com/virtual-kubelet/systemk/internal/unit"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// The OOM score adjustments the kubelet uses.
+const (
+	guaranteedOOMScoreAdj = -997
+	besteffortOOMScoreAdj = 1000
+
+	// systemCriticalPriority is the priority from which on Pods are critical, their containers get the OOM score
+	// adjustment of Guaranteed Pods regardless of their QoS class.
+	systemCriticalPriority = 2000000000
+)
+
+// qosSliceProperties are the cgroup directives of the parent slices of each QoS class. Guaranteed Pods get a larger
+// share of the CPU than Burstable ones, BestEffort Pods get what is left. For the MemoryLow= of the Pods and their
+// containers to have any effect their parents must have it set too, so only BestEffort Pods are not protected.
+var qosSliceProperties = map[corev1.PodQOSClass]map[string]string{
+	corev1.PodQOSGuaranteed: {"CPUWeight": "1000", "MemoryLow": "infinity"},
+	corev1.PodQOSBurstable:  {"CPUWeight": "100", "MemoryLow": "infinity"},
+	corev1.PodQOSBestEffort: {"CPUWeight": "1", "MemoryLow": "0"},
+}
+
+// rootSliceProperties are the cgroup directives of systemk.slice, the parent of the QoS classes' slices.
+var rootSliceProperties = map[string]string{"CPUWeight": "100", "MemoryLow": "infinity"}
+
+// podQOS returns the QoS class of pod, following the kubelet's rules:
+//
+// * BestEffort when none of the containers have CPU or memory requests or limits;
+// * Guaranteed when all containers have CPU and memory limits and the requests equal the limits;
+// * Burstable otherwise.
+func podQOS(pod *corev1.Pod) corev1.PodQOSClass {
+	requests, limits := corev1.ResourceList{}, corev1.ResourceList{}
+	guaranteed := true
+	for _, c := range append(pod.Spec.InitContainers, pod.Spec.Containers...) {
+		for name, q := range c.Resources.Requests {
+			if qosResource(name) && !q.IsZero() {
+				addQuantity(requests, name, q)
+			}
+		}
+		found := 0
+		for name, q := range c.Resources.Limits {
+			if qosResource(name) && !q.IsZero() {
+				addQuantity(limits, name, q)
+				found++
+			}
+		}
+		if found != 2 {
+			guaranteed = false
+		}
+	}
+	if len(requests) == 0 && len(limits) == 0 {
+		return corev1.PodQOSBestEffort
+	}
+	if guaranteed {
+		for name, req := range requests {
+			if lim, ok := limits[name]; !ok || lim.Cmp(req) != 0 {
+				guaranteed = false
+			}
+		}
+	}
+	if guaranteed && len(requests) == len(limits) {
+		return corev1.PodQOSGuaranteed
+	}
+	return corev1.PodQOSBurstable
+}
+
+func qosResource(name corev1.ResourceName) bool {
+	return name == corev1.ResourceCPU || name == corev1.ResourceMemory
+}
+
+func addQuantity(l corev1.ResourceList, name corev1.ResourceName, q resource.Quantity) {
+	sum := l[name]
+	sum.Add(q)
+	l[name] = sum
+}
+
+// oomScoreAdjust returns the OOMScoreAdjust= of container c of pod, following the kubelet's rules: critical and
+// Guaranteed Pods are the last to be killed, BestEffort Pods the first. The containers of Burstable Pods are killed
+// in order of how little memory they requested compared to the node's memory capacity.
+func oomScoreAdjust(pod *corev1.Pod, c corev1.Container, qos corev1.PodQOSClass, memoryCapacity int64) int {
+	if pod.Spec.Priority != nil && *pod.Spec.Priority >= systemCriticalPriority {
+		return guaranteedOOMScoreAdj
+	}
+	switch qos {
+	case corev1.PodQOSGuaranteed:
+		return guaranteedOOMScoreAdj
+	case corev1.PodQOSBestEffort:
+		return besteffortOOMScoreAdj
+	}
+	if memoryCapacity <= 0 {
+		return besteffortOOMScoreAdj - 1
+	}
+	request := c.Resources.Requests.Memory().Value()
+	adj := 1000 - (1000*request)/memoryCapacity
+	// Burstable containers must be killed before Guaranteed ones and after BestEffort ones.
+	if adj < 1000+guaranteedOOMScoreAdj {
+		return 1000 + guaranteedOOMScoreAdj
+	}
+	if adj >= besteffortOOMScoreAdj {
+		return besteffortOOMScoreAdj - 1
+	}
+	return int(adj)
+}
+
+// qosSlice returns the name of the parent slice of the Pods with QoS class qos: systemk-<qos>.slice.
+func qosSlice(qos corev1.PodQOSClass) string {
+	return prefix + "-" + strings.ToLower(string(qos)) + unit.SliceSuffix
+}
+
+// parentSliceUnit returns a slice unit with the cgroup directives props.
+func parentSliceUnit(description string, props map[string]string) (*unit.File, error) {
+	uf, err := unit.NewFile("[Unit]\nDescription=" + description + "\n")
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range []string{"CPUWeight", "MemoryLow"} {
+		uf = uf.Overwrite("Slice", k, props[k])
+	}
+	return uf, nil
+}
+
+// unitToQOS returns the QoS class recorded in the unit.
+func unitToQOS(uf *unit.File) corev1.PodQOSClass {
+	return corev1.PodQOSClass(lastValue(uf, kubernetesSection, "QOSClass"))
+}
@@ -0,0 +1,67 @@
+package provider
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestPodQOS(t *testing.T) {
+	list := func(cpu, mem string) corev1.ResourceList {
+		l := corev1.ResourceList{}
+		if cpu != "" {
+			l[corev1.ResourceCPU] = resource.MustParse(cpu)
+		}
+		if mem != "" {
+			l[corev1.ResourceMemory] = resource.MustParse(mem)
+		}
+		return l
+	}
+	tests := []struct {
+		resources []corev1.ResourceRequirements
+		qos       corev1.PodQOSClass
+	}{
+		{[]corev1.ResourceRequirements{{}}, corev1.PodQOSBestEffort},
+		{[]corev1.ResourceRequirements{{Requests: list("1", "1Gi"), Limits: list("1", "1Gi")}}, corev1.PodQOSGuaranteed},
+		{[]corev1.ResourceRequirements{{Requests: list("1", "1Gi"), Limits: list("2", "1Gi")}}, corev1.PodQOSBurstable},
+		{[]corev1.ResourceRequirements{{Requests: list("1", "")}}, corev1.PodQOSBurstable},
+		{[]corev1.ResourceRequirements{{Requests: list("1", "1Gi"), Limits: list("1", "1Gi")}, {}}, corev1.PodQOSBurstable},
+	}
+	for i, tc := range tests {
+		pod := &corev1.Pod{}
+		for _, r := range tc.resources {
+			pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{Resources: r})
+		}
+		if qos := podQOS(pod); qos != tc.qos {
+			t.Errorf("test %d, expected QoS class %s, got %s", i, tc.qos, qos)
+		}
+	}
+}
+
+func TestOOMScoreAdjust(t *testing.T) {
+	const gi = 1 << 30
+	critical := int32(systemCriticalPriority)
+	c := corev1.Container{Resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("1Gi")}}}
+	tests := []struct {
+		priority *int32
+		qos      corev1.PodQOSClass
+		adj      int
+	}{
+		{nil, corev1.PodQOSGuaranteed, guaranteedOOMScoreAdj},
+		{nil, corev1.PodQOSBestEffort, besteffortOOMScoreAdj},
+		{nil, corev1.PodQOSBurstable, 750}, // 1Gi out of 4Gi
+		{&critical, corev1.PodQOSBestEffort, guaranteedOOMScoreAdj},
+	}
+	for i, tc := range tests {
+		pod := &corev1.Pod{}
+		pod.Spec.Priority = tc.priority
+		if adj := oomScoreAdjust(pod, c, tc.qos, 4*gi); adj != tc.adj {
+			t.Errorf("test %d, expected OOM score adjustment %d, got %d", i, tc.adj, adj)
+		}
+	}
+	// A Burstable container without a memory request is killed right after the BestEffort ones.
+	if adj := oomScoreAdjust(&corev1.Pod{}, corev1.Container{}, corev1.PodQOSBurstable, 4*gi); adj != besteffortOOMScoreAdj-1 {
+		t.Errorf("expected OOM score adjustment %d, got %d", besteffortOOMScoreAdj-1, adj)
+	}
+}
@@ -0,0 +1,125 @@
+package provider
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/virtual-kubelet/systemk/internal/unit"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// defaultTasksMax is the maximum number of tasks (processes and threads) a unit may create. This stops a fork bomb
+// in a single container from taking down the entire host.
+const defaultTasksMax = "4096"
+
+// resourcesToUnit translates the container's resource requests and limits into systemd's cgroup directives:
+//
+// * the CPU limit becomes CPUQuota=, 1 CPU is 100%;
+// * the CPU request becomes CPUWeight=, 1 CPU is the systemd default weight of 100;
+// * the memory limit becomes MemoryMax=;
+// * the memory request becomes MemoryLow=.
+//
+// TasksMax= is always set.
+func resourcesToUnit(uf *unit.File, r corev1.ResourceRequirements) *unit.File {
+	uf = resourcesToSection(uf, "Service", r)
+	uf = uf.Overwrite("Service", "TasksMax", defaultTasksMax)
+	return uf
+}
+
+// resourcesToSection writes the cgroup directives of resourcesToUnit to section, which is Service for a container's
+// unit and Slice for the Pod's slice.
+func resourcesToSection(uf *unit.File, section string, r corev1.ResourceRequirements) *unit.File {
+	if cpu, ok := r.Limits[corev1.ResourceCPU]; ok {
+		uf = uf.Overwrite(section, "CPUQuota", cpuQuota(cpu))
+	}
+	if cpu, ok := r.Requests[corev1.ResourceCPU]; ok {
+		uf = uf.Overwrite(section, "CPUWeight", cpuWeight(cpu))
+	}
+	if mem, ok := r.Limits[corev1.ResourceMemory]; ok {
+		uf = uf.Overwrite(section, "MemoryMax", strconv.FormatInt(mem.Value(), 10))
+	}
+	if mem, ok := r.Requests[corev1.ResourceMemory]; ok {
+		uf = uf.Overwrite(section, "MemoryLow", strconv.FormatInt(mem.Value(), 10))
+	}
+	return uf
+}
+
+// unitToResources is the reverse of resourcesToUnit, it reads the cgroup directives from the unit and returns the
+// resource requests and limits they represent.
+func unitToResources(uf *unit.File) corev1.ResourceRequirements {
+	r := corev1.ResourceRequirements{}
+	set := func(l *corev1.ResourceList, name corev1.ResourceName, q *resource.Quantity) {
+		if q == nil {
+			return
+		}
+		if *l == nil {
+			*l = corev1.ResourceList{}
+		}
+		(*l)[name] = *q
+	}
+
+	set(&r.Limits, corev1.ResourceCPU, cpuFromQuota(lastValue(uf, "Service", "CPUQuota")))
+	set(&r.Limits, corev1.ResourceMemory, memoryFromBytes(lastValue(uf, "Service", "MemoryMax")))
+	set(&r.Requests, corev1.ResourceCPU, cpuFromWeight(lastValue(uf, "Service", "CPUWeight")))
+	set(&r.Requests, corev1.ResourceMemory, memoryFromBytes(lastValue(uf, "Service", "MemoryLow")))
+	return r
+}
+
+// cpuQuota returns the CPUQuota= value for cpu. As systemd only accepts whole percentages we round up.
+func cpuQuota(cpu resource.Quantity) string {
+	milli := cpu.MilliValue()
+	pct := (milli + 9) / 10
+	if pct < 1 {
+		pct = 1
+	}
+	return strconv.FormatInt(pct, 10) + "%"
+}
+
+// cpuWeight returns the CPUWeight= value for cpu, clamped to the range systemd accepts.
+func cpuWeight(cpu resource.Quantity) string {
+	w := cpu.MilliValue() / 10
+	if w < 1 {
+		w = 1
+	}
+	if w > 10000 {
+		w = 10000
+	}
+	return strconv.FormatInt(w, 10)
+}
+
+func cpuFromQuota(s string) *resource.Quantity {
+	if !strings.HasSuffix(s, "%") {
+		return nil
+	}
+	pct, err := strconv.ParseInt(strings.TrimSuffix(s, "%"), 10, 64)
+	if err != nil {
+		return nil
+	}
+	return resource.NewMilliQuantity(pct*10, resource.DecimalSI)
+}
+
+func cpuFromWeight(s string) *resource.Quantity {
+	w, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return nil
+	}
+	return resource.NewMilliQuantity(w*10, resource.DecimalSI)
+}
+
+func memoryFromBytes(s string) *resource.Quantity {
+	b, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return nil
+	}
+	return resource.NewQuantity(b, resource.BinarySI)
+}
+
+// lastValue returns the last value of name in section, as that is the one systemd uses.
+func lastValue(uf *unit.File, section, name string) string {
+	values := uf.Contents[section][name]
+	if len(values) == 0 {
+		return ""
+	}
+	return values[len(values)-1]
+}
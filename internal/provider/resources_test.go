@@ -0,0 +1,59 @@
+package provider
+
+import (
+	"testing"
+
+	"github.com/virtual-kubelet/systemk/internal/unit"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestResourcesRoundTrip(t *testing.T) {
+	r := corev1.ResourceRequirements{
+		Limits: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse("1500m"),
+			corev1.ResourceMemory: resource.MustParse("128Mi"),
+		},
+		Requests: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse("250m"),
+			corev1.ResourceMemory: resource.MustParse("64Mi"),
+		},
+	}
+	uf, _ := unit.NewFile(synthUnit)
+	uf = resourcesToUnit(uf, r)
+
+	expect := map[string]string{
+		"CPUQuota":  "150%",
+		"CPUWeight": "25",
+		"MemoryMax": "134217728",
+		"MemoryLow": "67108864",
+		"TasksMax":  defaultTasksMax,
+	}
+	for k, v := range expect {
+		if x := lastValue(uf, "Service", k); x != v {
+			t.Errorf("expected %s to be %q, got %q", k, v, x)
+		}
+	}
+
+	got := unitToResources(uf)
+	for _, l := range []struct {
+		name      string
+		got, want corev1.ResourceList
+	}{{"limits", got.Limits, r.Limits}, {"requests", got.Requests, r.Requests}} {
+		for k, v := range l.want {
+			q := l.got[k]
+			if q.Cmp(v) != 0 {
+				t.Errorf("expected %s %s to be %s, got %s", l.name, k, v.String(), q.String())
+			}
+		}
+	}
+}
+
+func TestResourcesEmpty(t *testing.T) {
+	uf, _ := unit.NewFile(synthUnit)
+	uf = resourcesToUnit(uf, corev1.ResourceRequirements{})
+	r := unitToResources(uf)
+	if r.Limits != nil || r.Requests != nil {
+		t.Errorf("expected no resources, got %v", r)
+	}
+}
@@ -0,0 +1,44 @@
+package provider
+
+import (
+	"github.com/virtual-kubelet/systemk/internal/unit"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// The restart backoff mimics the kubelet's: it starts at 10s and doubles on every restart, until it is capped at
+// five minutes. RestartSteps= and RestartMaxDelaySec= need systemd v254 or later, older versions ignore them and
+// restart every 10s.
+const (
+	restartSec         = "10s"
+	restartSteps       = "5"
+	restartMaxDelaySec = "5min"
+)
+
+// crashLoopBackOff is the reason given for a container that is waiting to be restarted.
+const crashLoopBackOff = "CrashLoopBackOff"
+
+// restartPolicyToUnit translates the Pod's restart policy into systemd's Restart=:
+//
+// * Always becomes Restart=always;
+// * OnFailure becomes Restart=on-failure;
+// * Never doesn't restart.
+//
+// Unless the unit is always restarted, it's kept around after it exited (RemainAfterExit=), so that we can return
+// its state until DeletePod is called.
+func restartPolicyToUnit(uf *unit.File, policy corev1.RestartPolicy) *unit.File {
+	switch policy {
+	case corev1.RestartPolicyNever:
+		uf = uf.Overwrite("Service", "Restart", "no")
+		return uf.Overwrite("Service", "RemainAfterExit", "true")
+	case corev1.RestartPolicyOnFailure:
+		uf = uf.Overwrite("Service", "Restart", "on-failure")
+		uf = uf.Overwrite("Service", "RemainAfterExit", "true")
+	default: // Always is the default.
+		uf = uf.Overwrite("Service", "Restart", "always")
+		uf = uf.Delete("Service", "RemainAfterExit")
+	}
+	uf = uf.Overwrite("Service", "RestartSec", restartSec)
+	uf = uf.Overwrite("Service", "RestartSteps", restartSteps)
+	uf = uf.Overwrite("Service", "RestartMaxDelaySec", restartMaxDelaySec)
+	return uf
+}
@@ -0,0 +1,32 @@
+package provider
+
+import (
+	"testing"
+
+	"github.com/virtual-kubelet/systemk/internal/unit"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestRestartPolicyToUnit(t *testing.T) {
+	tests := []struct {
+		policy          corev1.RestartPolicy
+		restart         string
+		remainAfterExit string
+	}{
+		{corev1.RestartPolicyAlways, "always", ""},
+		{"", "always", ""},
+		{corev1.RestartPolicyOnFailure, "on-failure", "true"},
+		{corev1.RestartPolicyNever, "no", "true"},
+	}
+	for i, tc := range tests {
+		uf, _ := unit.NewFile(synthUnit)
+		uf = uf.Overwrite("Service", "RemainAfterExit", "true")
+		uf = restartPolicyToUnit(uf, tc.policy)
+		if x := lastValue(uf, "Service", "Restart"); x != tc.restart {
+			t.Errorf("test %d, expected Restart to be %q, got %q", i, tc.restart, x)
+		}
+		if x := lastValue(uf, "Service", "RemainAfterExit"); x != tc.remainAfterExit {
+			t.Errorf("test %d, expected RemainAfterExit to be %q, got %q", i, tc.remainAfterExit, x)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package provider
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/virtual-kubelet/systemk/internal/unit"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const podFile = "pod.json"
+
+// writePod persists pod in its directory in /var/run, so the bookkeeping that needs the Pod's spec can be restored
+// when systemk restarts, see restorePods. The file is removed together with the Pod's directory.
+func writePod(pod *corev1.Pod) {
+	dir := filepath.Join(varrun, string(pod.UID))
+	if err := os.MkdirAll(dir, dirPerms); err != nil {
+		log.Warnf("failed to persist pod %s/%s: %s", pod.Namespace, pod.Name, err)
+		return
+	}
+	buf, err := json.Marshal(pod)
+	if err != nil {
+		log.Warnf("failed to persist pod %s/%s: %s", pod.Namespace, pod.Name, err)
+		return
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, podFile), buf, 0600); err != nil {
+		log.Warnf("failed to persist pod %s/%s: %s", pod.Namespace, pod.Name, err)
+	}
+}
+
+// readPod returns the Pod with uid persisted by writePod.
+func readPod(uid types.UID) (*corev1.Pod, error) {
+	buf, err := ioutil.ReadFile(filepath.Join(varrun, string(uid), podFile))
+	if err != nil {
+		return nil, err
+	}
+	pod := &corev1.Pod{}
+	if err := json.Unmarshal(buf, pod); err != nil {
+		return nil, err
+	}
+	return pod, nil
+}
+
+// restoredPods returns the Pods persisted by writePod that still have units.
+func (p *p) restoredPods() []*corev1.Pod {
+	files, _ := filepath.Glob(filepath.Join(varrun, "*", podFile))
+	pods := []*corev1.Pod{}
+	for _, file := range files {
+		pod, err := readPod(types.UID(filepath.Base(filepath.Dir(file))))
+		if err != nil {
+			log.Warnf("failed to read %s: %s", file, err)
+			continue
+		}
+		states, err := p.unitStates(unitPrefix(pod.Namespace, pod.Name) + separator)
+		if err != nil {
+			continue
+		}
+		// A Pod with the same name may have been created since, only restore the one the units belong to.
+		for _, s := range states {
+			if uf, err := unit.NewFile(s.UnitData); err == nil && lastValue(uf, kubernetesSection, "Id") == string(pod.UID) {
+				pods = append(pods, pod)
+				break
+			}
+		}
+	}
+	return pods
+}
+
+// restorePods restarts what was lost when systemk restarted for the Pods that were already running: their probe
+// workers, the consoles of their containers with stdin or a tty, the refreshing of their service account tokens and
+// the watches on the ConfigMaps and Secrets they use.
+func (p *p) restorePods() {
+	for _, pod := range p.restoredPods() {
+		log.Infof("restoring pod %s/%s", pod.Namespace, pod.Name)
+		p.restoreConsoles(pod)
+		p.prober.add(pod)
+		p.tokens.register(pod)
+		p.podResourceManager.Watch(pod)
+	}
+}
+
+// restoreConsoles gives the units of pod's containers with stdin or a tty a new console. The pty or the write end of
+// the fifo they had was held by the systemk that's gone: the unit's TTYPath= may by now be another session's
+// terminal and its stdin is at end-of-file. The running units are restarted, so they're connected to the new one.
+func (p *p) restoreConsoles(pod *corev1.Pod) {
+	restart := []string{}
+	for _, c := range append(pod.Spec.InitContainers, pod.Spec.Containers...) {
+		if !c.Stdin && !c.TTY {
+			continue
+		}
+		name := podToUnitName(pod, c.Name)
+		s, err := p.unitState(name)
+		if err != nil || s == nil || s.UnitData == "" {
+			continue
+		}
+		uf, err := unit.NewFile(s.UnitData)
+		if err != nil {
+			continue
+		}
+		scratch, _ := unit.NewFile(s.UnitData)
+		if scratch, err = p.consoles.open(pod, c, name, scratch); err != nil {
+			log.Errorf("failed to set up stdin/tty of unit %q: %s", name, err)
+			continue
+		}
+		// Only the path of the pty changes, it's replaced in place so the unit stays the same as the one podUnits
+		// generates, see UpdatePod.
+		if path := lastValue(scratch, "Service", "TTYPath"); path != "" {
+			for _, o := range uf.Options {
+				if o.Section == "Service" && o.Name == "TTYPath" {
+					o.Value = path
+				}
+			}
+			if uf, err = unit.NewFile(uf.String()); err != nil {
+				continue
+			}
+		}
+		if err := p.loadUnit(name, uf); err != nil {
+			log.Errorf("failed to load unit %q: %s", name, err)
+			continue
+		}
+		switch s.ActiveState {
+		case "active", "activating", "reloading":
+			restart = append(restart, name)
+		}
+	}
+	if len(restart) == 0 {
+		return
+	}
+	if err := p.unitManager.Reload(); err != nil {
+		log.Errorf("failed to reload systemd: %s", err)
+	}
+	for _, name := range restart {
+		log.Infof("restarting unit %q, its console is new", name)
+		if err := p.unitManager.TriggerRestart(name); err != nil {
+			log.Errorf("failed to trigger restart for unit %q: %s", name, err)
+		}
+	}
+}
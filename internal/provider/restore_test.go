@@ -0,0 +1,58 @@
+package provider
+
+import (
+	"context"
+	"testing"
+
+	"github.com/virtual-kubelet/systemk/internal/kubernetes"
+	"github.com/virtual-kubelet/systemk/internal/ospkg"
+	"github.com/virtual-kubelet/systemk/internal/unit"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/informers"
+)
+
+func TestRestorePods(t *testing.T) {
+	log = &noopLogger{}
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+
+	restarted := new(p)
+	p := new(p)
+	p.pkgManager = &ospkg.NoopManager{}
+	p.unitManager, _ = unit.NewMockManager()
+	p.config = &Opts{NodeName: "localhost"}
+	p.podResourceManager = kubernetes.NewPodResourceWatcher(informers.NewSharedInformerFactory(nil, 0))
+
+	probe := &corev1.Probe{Handler: corev1.Handler{Exec: &corev1.ExecAction{Command: []string{"true"}}}, PeriodSeconds: 3600}
+	pod := &corev1.Pod{}
+	pod.Namespace, pod.Name, pod.UID = "default", "restore", "re-store"
+	pod.Spec.Containers = []corev1.Container{{Name: "a", Image: "bash", ReadinessProbe: probe}}
+	if err := p.CreatePod(ctx, pod); err != nil {
+		t.Fatal(err)
+	}
+	defer p.DeletePod(ctx, pod)
+
+	// A new provider, as after a restart of systemk, with the same units.
+	restarted.pkgManager, restarted.unitManager, restarted.config = p.pkgManager, p.unitManager, p.config
+	restarted.podResourceManager = p.podResourceManager
+	restarted.prober = newProber(ctx, restarted)
+	restarted.restorePods()
+	if _, ok := restarted.prober.workers[podToUnitName(pod, "a")]; !ok {
+		t.Errorf("expected the probe worker of container a to be restored")
+	}
+
+	// A Pod with the same name, but another UID, isn't restored.
+	p.unitManager.Unload(podToUnitName(pod, "a"))
+	other := pod.DeepCopy()
+	other.UID = "ot-her"
+	other.Spec.Containers[0].ReadinessProbe = nil
+	if err := p.CreatePod(ctx, other); err != nil {
+		t.Fatal(err)
+	}
+	defer p.DeletePod(ctx, other)
+	for _, restored := range p.restoredPods() {
+		if restored.UID == pod.UID {
+			t.Errorf("expected pod %s not to be restored", pod.UID)
+		}
+	}
+}
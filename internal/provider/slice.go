@@ -0,0 +1,169 @@
+package provider
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	systemdunit "github.com/coreos/go-systemd/v22/unit"
+	"github.com/virtual-kubelet/systemk/internal/unit"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// podReadyToStartContainers is the condition the kubelet uses to signal the Pod's sandbox has been created. Our
+// sandbox is the Pod's slice. The constant doesn't exist in the Kubernetes API version systemk is built against.
+const podReadyToStartContainers corev1.PodConditionType = "PodReadyToStartContainers"
+
+// unitNameMax is the maximum length of a unit's name in systemd.
+const unitNameMax = 256
+
+// namespaceSliceMax is the maximum length of the escaped namespace in a slice name, which leaves room for the
+// escaped name of the Pod.
+const namespaceSliceMax = 100
+
+// podSlice returns the name of the slice all units of a Pod with QoS class qos run in:
+// systemk-<qos>-<namespace>-<name>.slice. A dash denotes the hierarchy of slices, so the namespace and name are
+// escaped; the slice is created in systemk-<qos>-<namespace>.slice, which is in systemk-<qos>.slice (see qosSlice),
+// which is in systemk.slice. An escaped name that makes the slice's name too long for systemd is replaced by its
+// hash, see sliceElement.
+func podSlice(qos corev1.PodQOSClass, namespace, name string) string {
+	parent := namespaceSlice(qos, namespace)
+	return parent + "-" + sliceElement(name, unitNameMax-len(parent)-len("-")-len(unit.SliceSuffix)) + unit.SliceSuffix
+}
+
+// namespaceSlice returns the name of the slice of namespace, without the suffix.
+func namespaceSlice(qos corev1.PodQOSClass, namespace string) string {
+	return strings.TrimSuffix(qosSlice(qos), unit.SliceSuffix) + "-" + sliceElement(namespace, namespaceSliceMax)
+}
+
+// sliceElement returns s escaped for use in a slice's name. If that's longer than max it returns "_" followed by
+// the start of the hex encoded SHA-256 of s instead: a Kubernetes name can't contain an underscore, so this can't
+// be the escaped name of another namespace or Pod.
+func sliceElement(s string, max int) string {
+	escaped := systemdunit.UnitNameEscape(s)
+	if len(escaped) <= max {
+		return escaped
+	}
+	sum := sha256.Sum256([]byte(s))
+	return "_" + hex.EncodeToString(sum[:])[:32]
+}
+
+// podSliceUnit returns the slice unit of pod. The Pod's aggregate requests and limits (see podResources) are applied
+// to the slice.
+func podSliceUnit(pod *corev1.Pod) (*unit.File, error) {
+	uf, err := unit.NewFile(fmt.Sprintf("[Unit]\nDescription=systemk pod %s/%s\n", pod.Namespace, pod.Name))
+	if err != nil {
+		return nil, err
+	}
+	uf = resourcesToSection(uf, "Slice", podResources(pod))
+	uf = uf.Insert(kubernetesSection, "Namespace", pod.Namespace)
+	uf = uf.Insert(kubernetesSection, "Id", string(pod.UID))
+	return uf, nil
+}
+
+// podResources returns the Pod's effective requests and limits, like the kubelet computes them for the Pod's cgroup:
+// the largest of the sum of the containers and of any of the init containers (as those run one after the other),
+// plus the Pod's overhead. A limit is only returned when all containers have one, otherwise the Pod is unlimited.
+func podResources(pod *corev1.Pod) corev1.ResourceRequirements {
+	r := corev1.ResourceRequirements{Requests: corev1.ResourceList{}, Limits: corev1.ResourceList{}}
+	for _, name := range []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory} {
+		if q, ok := podResource(pod, name, func(c corev1.Container) corev1.ResourceList { return c.Resources.Requests }, false); ok {
+			r.Requests[name] = q
+		}
+		if q, ok := podResource(pod, name, func(c corev1.Container) corev1.ResourceList { return c.Resources.Limits }, true); ok {
+			r.Limits[name] = q
+		}
+	}
+	return r
+}
+
+// podResource returns the Pod's effective quantity of resource name, as given by list. If all is true every
+// container must have the resource set.
+func podResource(pod *corev1.Pod, name corev1.ResourceName, list func(corev1.Container) corev1.ResourceList, all bool) (resource.Quantity, bool) {
+	sum, found := resource.Quantity{}, false
+	for _, c := range pod.Spec.Containers {
+		q, ok := list(c)[name]
+		if !ok {
+			if all {
+				return resource.Quantity{}, false
+			}
+			continue
+		}
+		sum.Add(q)
+		found = true
+	}
+	for _, c := range pod.Spec.InitContainers {
+		q, ok := list(c)[name]
+		if !ok {
+			if all {
+				return resource.Quantity{}, false
+			}
+			continue
+		}
+		if q.Cmp(sum) > 0 {
+			sum = q.DeepCopy()
+		}
+		found = true
+	}
+	if !found {
+		return resource.Quantity{}, false
+	}
+	if q, ok := pod.Spec.Overhead[name]; ok {
+		sum.Add(q)
+	}
+	return sum, true
+}
+
+// loadPodSlice writes the slice unit of pod and those of its parents. The parents are written every time, as they're
+// shared between Pods and there is no good moment to remove them.
+func (p *p) loadPodSlice(pod *corev1.Pod) error {
+	qos := podQOS(pod)
+	slices := []struct {
+		name        string
+		description string
+		props       map[string]string
+	}{
+		{prefix + unit.SliceSuffix, "systemk pods", rootSliceProperties},
+		{qosSlice(qos), "systemk " + string(qos) + " pods", qosSliceProperties[qos]},
+		{namespaceSlice(qos, pod.Namespace) + unit.SliceSuffix, "systemk " + string(qos) + " pods in " + pod.Namespace, qosSliceProperties[qos]},
+	}
+	for _, s := range slices {
+		uf, err := parentSliceUnit(s.description, s.props)
+		if err != nil {
+			return err
+		}
+		if err := p.unitManager.Load(s.name, *uf); err != nil {
+			return err
+		}
+	}
+
+	uf, err := podSliceUnit(pod)
+	if err != nil {
+		return err
+	}
+	return p.loadUnit(podSlice(qos, pod.Namespace, pod.Name), uf)
+}
+
+// isPodSlice returns true if name, with unit file data, is the slice of a Pod: a systemk slice that carries the
+// Pod's Id (see podSliceUnit), which its parent slices don't.
+func isPodSlice(name, data string) bool {
+	if !strings.HasPrefix(name, prefix+"-") || !strings.HasSuffix(name, unit.SliceSuffix) {
+		return false
+	}
+	uf, err := unit.NewFile(data)
+	return err == nil && lastValue(uf, kubernetesSection, "Id") != ""
+}
+
+// sandboxReady returns true if the slice of the Pod is active. The slice's state comes from the cache if there is
+// one, like the states of the Pod's units.
+func (p *p) sandboxReady(qos corev1.PodQOSClass, namespace, name string) bool {
+	slice := podSlice(qos, namespace, name)
+	if p.cache != nil {
+		s, ok := p.cache.states(slice)[slice]
+		return ok && s.ActiveState == "active"
+	}
+	s, err := p.unitManager.State(slice)
+	return err == nil && s != nil && s.ActiveState == "active"
+}
@@ -0,0 +1,157 @@
+package provider
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/virtual-kubelet/systemk/internal/kubernetes"
+	"github.com/virtual-kubelet/systemk/internal/ospkg"
+	"github.com/virtual-kubelet/systemk/internal/unit"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/client-go/informers"
+)
+
+func TestPodSlice(t *testing.T) {
+	if x := podSlice(corev1.PodQOSBestEffort, "kube-system", "core-dns"); x != `systemk-besteffort-kube\x2dsystem-core\x2ddns.slice` {
+		t.Errorf("expected slice name to be escaped, got %s", x)
+	}
+
+	// Names that are too long once escaped are hashed, so the slice can be loaded. The hash can't clash with the
+	// escaped name of another Pod.
+	namespace, name := strings.Repeat("a-", 31)+"a", strings.Repeat("b-", 126)+"b"
+	slice := podSlice(corev1.PodQOSBurstable, namespace, name)
+	if len(slice) > unitNameMax {
+		t.Errorf("expected slice name to be at most %d characters, got %d: %s", unitNameMax, len(slice), slice)
+	}
+	if strings.Count(slice, "-") != 3 || !strings.Contains(slice, "-_") {
+		t.Errorf("expected the namespace and name to be hashed, got %s", slice)
+	}
+	if other := podSlice(corev1.PodQOSBurstable, namespace, name+"c"); other == slice {
+		t.Errorf("expected other names to have other slices, got %s", other)
+	}
+	if x := podSlice(corev1.PodQOSBurstable, "default", name); !strings.HasPrefix(x, "systemk-burstable-default-_") || len(x) > unitNameMax {
+		t.Errorf("expected only the name to be hashed, got %s", x)
+	}
+}
+
+func TestPodSliceUnit(t *testing.T) {
+	limits := func(cpu, mem string) corev1.ResourceRequirements {
+		return corev1.ResourceRequirements{
+			Limits:   corev1.ResourceList{corev1.ResourceCPU: resource.MustParse(cpu), corev1.ResourceMemory: resource.MustParse(mem)},
+			Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse(cpu)},
+		}
+	}
+	pod := &corev1.Pod{}
+	pod.Namespace, pod.Name = "default", "slice"
+	pod.Spec.InitContainers = []corev1.Container{{Name: "init", Resources: limits("2", "64Mi")}}
+	pod.Spec.Containers = []corev1.Container{{Name: "a", Resources: limits("500m", "64Mi")}, {Name: "b", Resources: limits("500m", "64Mi")}}
+	pod.Spec.Overhead = corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("16Mi")}
+
+	uf, err := podSliceUnit(pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := map[string]string{
+		"CPUQuota":  "200%",      // the init container needs more than the containers together
+		"CPUWeight": "200",       // idem
+		"MemoryMax": "150994944", // 2 * 64Mi + 16Mi overhead
+		"MemoryLow": "",          // no memory requests
+	}
+	for k, v := range expect {
+		if x := lastValue(uf, "Slice", k); x != v {
+			t.Errorf("expected %s to be %q, got %q", k, v, x)
+		}
+	}
+
+	// Without a limit on all containers, the Pod isn't limited.
+	pod.Spec.Containers[1].Resources.Limits = nil
+	uf, _ = podSliceUnit(pod)
+	if x := lastValue(uf, "Slice", "MemoryMax"); x != "" {
+		t.Errorf("expected no MemoryMax, got %q", x)
+	}
+}
+
+func TestCreatePodSlice(t *testing.T) {
+	log = &noopLogger{}
+	p := new(p)
+	p.pkgManager = &ospkg.NoopManager{}
+	p.unitManager, _ = unit.NewMockManager()
+	p.config = &Opts{NodeName: "localhost"}
+	p.podResourceManager = kubernetes.NewPodResourceWatcher(informers.NewSharedInformerFactory(nil, 0))
+
+	pod := &corev1.Pod{}
+	pod.Namespace, pod.Name, pod.UID = "default", "slice", "aa-bb"
+	pod.Spec.Containers = []corev1.Container{{Name: "a", Image: "bash"}}
+	if err := p.CreatePod(context.TODO(), pod); err != nil {
+		t.Fatal(err)
+	}
+	slice := podSlice(corev1.PodQOSBestEffort, pod.Namespace, pod.Name)
+	if p.unitManager.Unit(slice) == "" {
+		t.Fatalf("expected slice %q to be loaded", slice)
+	}
+	uf, _ := unit.NewFile(p.unitManager.Unit(podToUnitName(pod, "a")))
+	if x := lastValue(uf, "Service", "Slice"); x != slice {
+		t.Errorf("expected unit to run in slice %q, got %q", slice, x)
+	}
+
+	if err := p.DeletePod(context.TODO(), pod); err != nil {
+		t.Fatal(err)
+	}
+	if p.unitManager.Unit(slice) != "" {
+		t.Errorf("expected slice %q to be unloaded", slice)
+	}
+}
+
+// activeSlices reports slices as active and counts the State calls, i.e. the D-Bus round trips.
+type activeSlices struct {
+	unit.Manager
+	calls int
+}
+
+func (a *activeSlices) State(name string) (*unit.State, error) {
+	a.calls++
+	s := &unit.State{}
+	if strings.HasSuffix(name, unit.SliceSuffix) {
+		s.ActiveState = "active"
+	}
+	return s, nil
+}
+
+func TestSandboxReadyCached(t *testing.T) {
+	log = &noopLogger{}
+	dir := t.TempDir()
+	mock, _ := unit.NewMockManager()
+	m := &activeSlices{Manager: mock}
+	p := new(p)
+	p.pkgManager = &ospkg.NoopManager{}
+	p.unitManager = m
+	p.cache = newUnitCache(m, dir)
+	p.config = &Opts{NodeName: "localhost"}
+	p.podResourceManager = kubernetes.NewPodResourceWatcher(informers.NewSharedInformerFactory(nil, 0))
+
+	pod := &corev1.Pod{}
+	pod.Namespace, pod.Name, pod.UID = "default", "sandbox", "sa-nd"
+	pod.Spec.Containers = []corev1.Container{{Name: "a", Image: "bash"}}
+	if err := p.CreatePod(context.TODO(), pod); err != nil {
+		t.Fatal(err)
+	}
+	defer p.DeletePod(context.TODO(), pod)
+
+	calls := m.calls
+	if !p.sandboxReady(corev1.PodQOSBestEffort, pod.Namespace, pod.Name) {
+		t.Errorf("expected sandbox to be ready")
+	}
+	if _, err := p.GetPod(context.TODO(), pod.Namespace, pod.Name); err != nil {
+		t.Fatal(err)
+	}
+	if m.calls != calls {
+		t.Errorf("expected the slice's state to come from the cache, got %d D-Bus calls", m.calls-calls)
+	}
+	slice := podSlice(corev1.PodQOSBestEffort, pod.Namespace, pod.Name)
+	parent := namespaceSlice(corev1.PodQOSBestEffort, pod.Namespace) + unit.SliceSuffix
+	if !isPodSlice(slice, mock.Unit(slice)) || isPodSlice(parent, mock.Unit(parent)) {
+		t.Errorf("expected only the pod's slice to be a pod slice")
+	}
+}
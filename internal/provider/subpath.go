@@ -0,0 +1,256 @@
+package provider
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// subPathsDir holds the staging mounts of the subPaths, see stageSubPath.
+const subPathsDir = "subpaths"
+
+// subPath returns the path inside the volume that mount m refers to: its subPath, or its subPathExpr with the $(VAR)
+// references expanded from the container's environment env. Like the kubelet, the path must be relative and can't
+// step out of the volume with "..".
+func subPath(m corev1.VolumeMount, env []corev1.EnvVar) (string, error) {
+	sub := m.SubPath
+	if m.SubPathExpr != "" {
+		values := map[string]string{}
+		for _, e := range env {
+			values[e.Name] = e.Value
+		}
+		sub = expandEnv(m.SubPathExpr, values)
+	}
+	if sub == "" {
+		return "", nil
+	}
+	if filepath.IsAbs(sub) {
+		return "", fmt.Errorf("%q must be a relative path", sub)
+	}
+	for _, elem := range strings.Split(filepath.ToSlash(sub), "/") {
+		if elem == ".." {
+			return "", fmt.Errorf("%q must not contain '..'", sub)
+		}
+	}
+	return sub, nil
+}
+
+// resolveSubPath returns the path of sub in the volume at dir, with all symbolic links resolved. A symbolic link that points outside of the volume is an error, as the Pod could otherwise mount any path
+// of the host. Missing directories are created, owned by uid and gid, as the kubelet does; the last element of sub
+// may also be an existing file, which is then mounted on its own.
+func resolveSubPath(dir, sub, uid, gid string) (string, error) {
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", err
+	}
+
+	// Walk the path one element at a time, so we never create a directory outside of the volume.
+	path := root
+	for _, elem := range strings.Split(filepath.Clean(sub), string(filepath.Separator)) {
+		if elem == "" || elem == "." {
+			continue
+		}
+		next := filepath.Join(path, elem)
+		if _, err := os.Lstat(next); os.IsNotExist(err) {
+			if err := mkdirAllChown(next, dirPerms, uid, gid); err != nil {
+				return "", err
+			}
+		} else if err != nil {
+			return "", err
+		}
+		if next, err = filepath.EvalSymlinks(next); err != nil {
+			return "", err
+		}
+		if !within(root, next) {
+			return "", fmt.Errorf("%q resolves to %q, outside of the volume", sub, next)
+		}
+		path = next
+	}
+	return path, nil
+}
+
+// within returns true if path is root or below it.
+func within(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// stageSubPath bind mounts sub of the volume at dir on a staging path that is private to the i-th volume mount of
+// container c, and returns it; the unit then bind mounts the staging path. systemd mounts the unit's BindPaths= on
+// every start and mount(2) follows symbolic links, so binding the subPath itself would let another container that
+// can write to the volume swap it for a link to e.g. / in between. Like the kubelet, the resolved path is opened
+// one element at a time without following symbolic links and the bind mount is made from the open file, so what's
+// mounted is what was checked. A staging path that is mounted already is reused if it's still what the subPath
+// resolves to, otherwise, e.g. when a subPathExpr expands differently after an update, it's staged again.
+func stageSubPath(pod *corev1.Pod, c corev1.Container, i int, dir, sub, uid, gid string) (string, error) {
+	staging := subPathStaging(pod, c, i)
+	mounted, err := isBindMount(staging)
+	if err != nil {
+		return "", err
+	}
+
+	path, err := resolveSubPath(dir, sub, uid, gid)
+	if err != nil {
+		return "", err
+	}
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", err
+	}
+	fd, err := openNoFollow(root, path)
+	if err != nil {
+		return "", err
+	}
+	defer unix.Close(fd)
+
+	var st unix.Stat_t
+	if err := unix.Fstat(fd, &st); err != nil {
+		return "", err
+	}
+	if mounted {
+		var cur unix.Stat_t
+		if err := unix.Stat(staging, &cur); err == nil && cur.Dev == st.Dev && cur.Ino == st.Ino {
+			return staging, nil
+		}
+		if err := unix.Unmount(staging, unix.MNT_DETACH); err != nil {
+			return "", fmt.Errorf("failed to unmount %q: %s", staging, err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(staging), 0750); err != nil {
+		return "", err
+	}
+	// The mount point must be of the same type as what's mounted on it, a leftover of another type is replaced.
+	if err := os.Remove(staging); err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	if st.Mode&unix.S_IFMT == unix.S_IFDIR {
+		err = os.Mkdir(staging, 0750)
+	} else {
+		var f *os.File
+		if f, err = os.OpenFile(staging, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0640); err == nil {
+			f.Close()
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	if err := unix.Mount(fmt.Sprintf("/proc/self/fd/%d", fd), staging, "", unix.MS_BIND, ""); err != nil {
+		return "", fmt.Errorf("failed to bind mount %q on %q: %s", path, staging, err)
+	}
+	return staging, nil
+}
+
+// openNoFollow opens path, which must be in root, one element at a time with O_NOFOLLOW and returns the O_PATH file
+// descriptor. As path has its symbolic links resolved already, finding one means it was changed in the mean time,
+// which is an error.
+func openNoFollow(root, path string) (int, error) {
+	if !within(root, path) {
+		return -1, fmt.Errorf("%q is outside of %q", path, root)
+	}
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return -1, err
+	}
+	fd, err := unix.Open(root, unix.O_PATH|unix.O_DIRECTORY|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return -1, fmt.Errorf("failed to open %q: %s", root, err)
+	}
+	current := root
+	for _, elem := range strings.Split(rel, string(filepath.Separator)) {
+		if elem == "" || elem == "." {
+			continue
+		}
+		current = filepath.Join(current, elem)
+		next, err := unix.Openat(fd, elem, unix.O_PATH|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+		unix.Close(fd)
+		if err != nil {
+			return -1, fmt.Errorf("failed to open %q: %s", current, err)
+		}
+		fd = next
+		var st unix.Stat_t
+		if err := unix.Fstat(fd, &st); err != nil {
+			unix.Close(fd)
+			return -1, err
+		}
+		if st.Mode&unix.S_IFMT == unix.S_IFLNK {
+			unix.Close(fd)
+			return -1, fmt.Errorf("%q changed into a symbolic link", current)
+		}
+	}
+	return fd, nil
+}
+
+// subPathStaging returns the staging path of the subPath of the i-th volume mount of container c, see stageSubPath.
+func subPathStaging(pod *corev1.Pod, c corev1.Container, i int) string {
+	return filepath.Join(varrun, string(pod.UID), subPathsDir, c.Name, strconv.Itoa(i))
+}
+
+// isBindMount returns true if something is mounted on path. Unlike isMountPoint this also finds bind mounts from
+// the same filesystem, by looking path up in the mount table.
+func isBindMount(path string) (bool, error) {
+	// The mount table has the paths with their symbolic links resolved, /var/run usually is one.
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// The mount point is the fifth field, with spaces and the like escaped in octal.
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 4 && unescapeMountInfo(fields[4]) == path {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+// unescapeMountInfo undoes the octal escapes (e.g. \040 for a space) of a path in /proc/self/mountinfo.
+func unescapeMountInfo(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	b := strings.Builder{}
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if n, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// unmountSubPaths unmounts the staging mounts of the Pod's subPaths, so its directory can be removed. An error is
+// returned if one is still mounted, removing the directory would then remove what's in the volume.
+func unmountSubPaths(podId string) error {
+	staged, _ := filepath.Glob(filepath.Join(varrun, podId, subPathsDir, "*", "*"))
+	for _, path := range staged {
+		mounted, err := isBindMount(path)
+		if err != nil {
+			return err
+		}
+		if !mounted {
+			continue
+		}
+		if err := unix.Unmount(path, unix.MNT_DETACH); err != nil {
+			return fmt.Errorf("failed to unmount %q: %s", path, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,284 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/virtual-kubelet/systemk/internal/kubernetes"
+	"github.com/virtual-kubelet/systemk/internal/ospkg"
+	"github.com/virtual-kubelet/systemk/internal/unit"
+	"golang.org/x/sys/unix"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+)
+
+func TestSubPath(t *testing.T) {
+	env := []corev1.EnvVar{{Name: "POD_NAME", Value: "web-0"}}
+	tests := []struct {
+		mount corev1.VolumeMount
+		exp   string
+		err   bool
+	}{
+		{corev1.VolumeMount{}, "", false},
+		{corev1.VolumeMount{SubPath: "config/app.yaml"}, "config/app.yaml", false},
+		{corev1.VolumeMount{SubPathExpr: "logs/$(POD_NAME)"}, "logs/web-0", false},
+		{corev1.VolumeMount{SubPath: "/etc"}, "", true},
+		{corev1.VolumeMount{SubPath: "a/../../etc"}, "", true},
+		{corev1.VolumeMount{SubPathExpr: "$(POD_NAME)/.."}, "", true},
+	}
+	for i, tc := range tests {
+		got, err := subPath(tc.mount, env)
+		if tc.err != (err != nil) {
+			t.Errorf("test %d, expected error to be %t, got %v", i, tc.err, err)
+			continue
+		}
+		if got != tc.exp {
+			t.Errorf("test %d, expected %q, got %q", i, tc.exp, got)
+		}
+	}
+}
+
+func TestResolveSubPath(t *testing.T) {
+	log = &noopLogger{}
+	dir := t.TempDir()
+	outside := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "key"), []byte("value"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("key", filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(dir, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	root, _ := filepath.EvalSymlinks(dir)
+
+	tests := []struct {
+		sub string
+		exp string
+		err bool
+	}{
+		{"key", filepath.Join(root, "key"), false},
+		{"link", filepath.Join(root, "key"), false},
+		{"new/dir", filepath.Join(root, "new/dir"), false},
+		{"escape", "", true},
+		{"escape/new", "", true},
+	}
+	for i, tc := range tests {
+		got, err := resolveSubPath(dir, tc.sub, "", "")
+		if tc.err != (err != nil) {
+			t.Errorf("test %d, expected error to be %t, got %v", i, tc.err, err)
+			continue
+		}
+		if got != tc.exp {
+			t.Errorf("test %d, expected %q, got %q", i, tc.exp, got)
+		}
+	}
+	if fi, err := os.Stat(filepath.Join(dir, "new/dir")); err != nil || !fi.IsDir() {
+		t.Errorf("expected subPath directory to be created, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "new")); !os.IsNotExist(err) {
+		t.Errorf("expected no directory to be created outside of the volume, got %v", err)
+	}
+}
+
+func TestOpenNoFollow(t *testing.T) {
+	dir := t.TempDir()
+	outside := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "data/logs"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	root, _ := filepath.EvalSymlinks(dir)
+	path, err := resolveSubPath(dir, "data/logs", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fd, err := openNoFollow(root, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unix.Close(fd)
+
+	// The checked directory is swapped for a symbolic link after it was resolved.
+	if err := os.Rename(filepath.Join(dir, "data"), filepath.Join(dir, "data.old")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(dir, "data")); err != nil {
+		t.Fatal(err)
+	}
+	if fd, err := openNoFollow(root, path); err == nil {
+		unix.Close(fd)
+		t.Errorf("expected an error for a path that changed into a symbolic link")
+	}
+	if _, err := openNoFollow(root, outside); err == nil {
+		t.Errorf("expected an error for a path outside of the volume")
+	}
+}
+
+func TestStageSubPath(t *testing.T) {
+	dir := t.TempDir()
+	outside := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "data"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "data", "marker"), []byte("volume"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "key"), []byte("value"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	pod := &corev1.Pod{}
+	pod.UID = "st-age"
+	c := corev1.Container{Name: "a"}
+	defer cleanPodEphemeralVolumes(string(pod.UID))
+
+	staging, err := stageSubPath(pod, c, 0, dir, "data", "", "")
+	if errors.Is(err, unix.EPERM) || (err != nil && strings.Contains(err.Error(), "operation not permitted")) {
+		t.Skip("bind mounts are not permitted")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := stageSubPath(pod, c, 1, dir, "key", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf, _ := ioutil.ReadFile(file); string(buf) != "value" {
+		t.Errorf("expected the key to be staged as a file, got %q", buf)
+	}
+
+	// Staging the same subPath again reuses the mount.
+	if again, err := stageSubPath(pod, c, 0, dir, "data", "", ""); err != nil || again != staging {
+		t.Errorf("expected the staging mount to be reused, got %q (%v)", again, err)
+	}
+
+	// Swapping the directory for a symbolic link afterwards doesn't change what's mounted, and staging it again
+	// fails rather than following the link.
+	if err := os.Rename(filepath.Join(dir, "data"), filepath.Join(dir, "data.old")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(dir, "data")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stageSubPath(pod, c, 0, dir, "data", "", ""); err == nil {
+		t.Errorf("expected an error for a subPath that resolves outside of the volume")
+	}
+	if buf, err := ioutil.ReadFile(filepath.Join(staging, "marker")); err != nil || string(buf) != "volume" {
+		t.Errorf("expected the original directory to be mounted, got %q (%v)", buf, err)
+	}
+
+	// A subPath that resolves to something else, e.g. after an update, is staged again.
+	if err := os.MkdirAll(filepath.Join(dir, "other"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "other", "marker"), []byte("other"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if again, err := stageSubPath(pod, c, 0, dir, "other", "", ""); err != nil || again != staging {
+		t.Errorf("expected the staging path to be reused, got %q (%v)", again, err)
+	}
+	if buf, err := ioutil.ReadFile(filepath.Join(staging, "marker")); err != nil || string(buf) != "other" {
+		t.Errorf("expected the other directory to be mounted, got %q (%v)", buf, err)
+	}
+	if file, err = stageSubPath(pod, c, 1, dir, "data.old", "", ""); err != nil {
+		t.Fatal(err)
+	}
+	if buf, err := ioutil.ReadFile(filepath.Join(file, "marker")); err != nil || string(buf) != "volume" {
+		t.Errorf("expected a directory to replace the staged file, got %q (%v)", buf, err)
+	}
+	if n := strings.Count(mountInfo(t), filepath.Join(string(pod.UID), subPathsDir)); n != 2 {
+		t.Errorf("expected 2 staging mounts, got %d", n)
+	}
+
+	if err := unmountSubPaths(string(pod.UID)); err != nil {
+		t.Fatal(err)
+	}
+	if mounted, _ := isBindMount(staging); mounted {
+		t.Errorf("expected %q to be unmounted", staging)
+	}
+}
+
+func mountInfo(t *testing.T) string {
+	buf, err := ioutil.ReadFile("/proc/self/mountinfo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buf)
+}
+
+func TestUnescapeMountInfo(t *testing.T) {
+	if x := unescapeMountInfo(`/var/run/a\040b`); x != "/var/run/a b" {
+		t.Errorf("expected %q, got %q", "/var/run/a b", x)
+	}
+}
+
+func TestSubPathConfigMapUpdate(t *testing.T) {
+	log = &noopLogger{}
+	factory := informers.NewSharedInformerFactory(nil, 0)
+	indexer := factory.Core().V1().ConfigMaps().Informer().GetIndexer()
+	cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "app"}, Data: map[string]string{"app.conf": "v1"}}
+	indexer.Add(cm)
+
+	p := new(p)
+	p.pkgManager = &ospkg.NoopManager{}
+	mock, _ := unit.NewMockManager()
+	rec := &restartRecorder{Manager: mock}
+	p.unitManager = rec
+	p.config = &Opts{NodeName: "localhost"}
+	p.podResourceManager = kubernetes.NewPodResourceWatcher(factory)
+
+	pod := &corev1.Pod{}
+	pod.Namespace, pod.Name, pod.UID = "default", "subpath", "cm-sub"
+	pod.Spec.Volumes = []corev1.Volume{{Name: "config", VolumeSource: corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{
+		LocalObjectReference: corev1.LocalObjectReference{Name: "app"},
+	}}}}
+	pod.Spec.Containers = []corev1.Container{{Name: "a", Image: "bash", VolumeMounts: []corev1.VolumeMount{
+		{Name: "config", MountPath: "/etc/app.conf", SubPath: "app.conf", ReadOnly: true},
+	}}}
+	err := p.CreatePod(context.TODO(), pod)
+	if err != nil && strings.Contains(err.Error(), "operation not permitted") {
+		t.Skip("bind mounts are not permitted")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.DeletePod(context.TODO(), pod)
+
+	uf, _ := unit.NewFile(mock.Unit(podToUnitName(pod, "a")))
+	bind := lastValue(uf, "Service", "BindReadOnlyPaths")
+	staging := strings.Split(strings.Fields(bind)[0], ":")[0]
+	if !strings.HasPrefix(staging, filepath.Join(varrun, string(pod.UID), subPathsDir)) {
+		t.Fatalf("expected the subPath to be mounted from its staging path, got BindReadOnlyPaths=%q", bind)
+	}
+
+	// The update removes the timestamped directory the subPath resolved to. Like with the kubelet the container
+	// keeps the contents it had, which must still be there; once the Pod's units are regenerated the subPath is
+	// staged again, so the container gets the new contents when it's restarted.
+	cm = cm.DeepCopy()
+	cm.Data["app.conf"] = "v2"
+	indexer.Update(cm)
+	if err := p.UpdateConfigMap(context.TODO(), pod, cm); err != nil {
+		t.Fatal(err)
+	}
+	if buf, _ := ioutil.ReadFile(filepath.Join(varrun, string(pod.UID), configmapDir, "#0", "app.conf")); string(buf) != "v2" {
+		t.Errorf("expected the volume to be updated, got %q", buf)
+	}
+	if buf, err := ioutil.ReadFile(staging); err != nil || string(buf) != "v1" {
+		t.Errorf("expected the staged subPath to still be there, got %q (%v)", buf, err)
+	}
+	if err := p.UpdatePod(context.TODO(), pod); err != nil {
+		t.Fatal(err)
+	}
+	if len(rec.restarted) != 0 {
+		t.Errorf("expected no restarts, got %v", rec.restarted)
+	}
+	if buf, err := ioutil.ReadFile(staging); err != nil || string(buf) != "v2" {
+		t.Errorf("expected the subPath to be staged again, got %q (%v)", buf, err)
+	}
+}
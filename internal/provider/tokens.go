@@ -0,0 +1,198 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+const (
+	// defaultTokenExpiration is the lifetime of a token when the projection doesn't specify one, this is also the
+	// API server's default.
+	defaultTokenExpiration = 3600
+
+	// tokenResync is how often the projected volumes with service account tokens are rewritten.
+	tokenResync = 1 * time.Minute
+)
+
+// tokenManager requests the service account tokens of Pods through the TokenRequest API and caches them until
+// they need to be refreshed. It also tracks which Pods have tokens, so their volumes can be rewritten with fresh
+// ones, see refreshTokens.
+type tokenManager struct {
+	client corev1client.ServiceAccountsGetter
+	now    func() time.Time
+
+	sync.Mutex
+	tokens map[string]*authenticationv1.TokenRequest
+
+	// podsMu is held while a Pod's volumes are rewritten, so forget waits for that to finish and a deleted Pod's
+	// volumes aren't recreated afterwards.
+	podsMu sync.Mutex
+	pods   map[types.UID]*corev1.Pod
+}
+
+func newTokenManager(client corev1client.ServiceAccountsGetter) *tokenManager {
+	return &tokenManager{
+		client: client,
+		now:    time.Now,
+		tokens: map[string]*authenticationv1.TokenRequest{},
+		pods:   map[types.UID]*corev1.Pod{},
+	}
+}
+
+// token returns the token for the service account of pod, with the projection's audience and expiration and
+// bound to the Pod, so it's invalidated when the Pod is deleted. A cached token is returned until it reaches 80% of
+// its lifetime.
+func (t *tokenManager) token(pod *corev1.Pod, source *corev1.ServiceAccountTokenProjection) ([]byte, error) {
+	if t == nil || t.client == nil {
+		return nil, fmt.Errorf("no client to request service account tokens with")
+	}
+	expiration := int64(defaultTokenExpiration)
+	if source.ExpirationSeconds != nil {
+		expiration = *source.ExpirationSeconds
+	}
+	key := fmt.Sprintf("%s/%s/%s/%d/%s", pod.Namespace, pod.Spec.ServiceAccountName, source.Audience, expiration, pod.UID)
+
+	t.Lock()
+	cached, ok := t.tokens[key]
+	t.Unlock()
+	if ok && !t.requiresRefresh(cached) {
+		return []byte(cached.Status.Token), nil
+	}
+
+	req := &authenticationv1.TokenRequest{
+		Spec: authenticationv1.TokenRequestSpec{
+			ExpirationSeconds: &expiration,
+			BoundObjectRef: &authenticationv1.BoundObjectReference{
+				APIVersion: "v1",
+				Kind:       "Pod",
+				Name:       pod.Name,
+				UID:        pod.UID,
+			},
+		},
+	}
+	if source.Audience != "" {
+		req.Spec.Audiences = []string{source.Audience}
+	}
+	tr, err := t.client.ServiceAccounts(pod.Namespace).CreateToken(context.TODO(), pod.Spec.ServiceAccountName, req, metav1.CreateOptions{})
+	if err != nil {
+		if ok && t.now().Before(cached.Status.ExpirationTimestamp.Time) {
+			// The cached token is still valid, try again later.
+			log.Warnf("failed to refresh token for service account %s/%s: %s", pod.Namespace, pod.Spec.ServiceAccountName, err)
+			return []byte(cached.Status.Token), nil
+		}
+		return nil, fmt.Errorf("failed to request token for service account %s/%s: %s", pod.Namespace, pod.Spec.ServiceAccountName, err)
+	}
+
+	t.Lock()
+	t.tokens[key] = tr
+	t.Unlock()
+	return []byte(tr.Status.Token), nil
+}
+
+// requiresRefresh returns true if tr reached 80% of its lifetime, like the kubelet does.
+func (t *tokenManager) requiresRefresh(tr *authenticationv1.TokenRequest) bool {
+	if tr.Spec.ExpirationSeconds == nil {
+		return true
+	}
+	ttl := time.Duration(*tr.Spec.ExpirationSeconds) * time.Second
+	exp := tr.Status.ExpirationTimestamp.Time
+	issued := exp.Add(-ttl)
+	return t.now().After(issued.Add(ttl * 8 / 10))
+}
+
+// register tracks pod if it has projected service account tokens, so they are refreshed.
+func (t *tokenManager) register(pod *corev1.Pod) {
+	if t == nil || !hasTokens(pod) {
+		return
+	}
+	t.podsMu.Lock()
+	defer t.podsMu.Unlock()
+	t.pods[pod.UID] = pod.DeepCopy()
+}
+
+// forget removes pod and its tokens. It waits for a refresh of the Pod's volumes that is in progress.
+func (t *tokenManager) forget(pod *corev1.Pod) {
+	if t == nil {
+		return
+	}
+	t.podsMu.Lock()
+	delete(t.pods, pod.UID)
+	t.podsMu.Unlock()
+
+	t.Lock()
+	defer t.Unlock()
+	for k := range t.tokens {
+		if strings.HasSuffix(k, "/"+string(pod.UID)) {
+			delete(t.tokens, k)
+		}
+	}
+}
+
+// podsWithTokens returns the UIDs of the Pods that have service account tokens.
+func (t *tokenManager) podsWithTokens() []types.UID {
+	if t == nil {
+		return nil
+	}
+	t.podsMu.Lock()
+	defer t.podsMu.Unlock()
+	uids := make([]types.UID, 0, len(t.pods))
+	for uid := range t.pods {
+		uids = append(uids, uid)
+	}
+	return uids
+}
+
+// refresh calls f with the Pod uid, unless it's no longer tracked. forget blocks until f returns.
+func (t *tokenManager) refresh(uid types.UID, f func(pod *corev1.Pod)) {
+	t.podsMu.Lock()
+	defer t.podsMu.Unlock()
+	if pod, ok := t.pods[uid]; ok {
+		f(pod)
+	}
+}
+
+// hasTokens returns true if pod has a projected volume with a service account token.
+func hasTokens(pod *corev1.Pod) bool {
+	for _, v := range pod.Spec.Volumes {
+		if v.Projected == nil {
+			continue
+		}
+		for _, source := range v.Projected.Sources {
+			if source.ServiceAccountToken != nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// refreshTokens rewrites the projected volumes of the Pods with service account tokens every tokenResync. Tokens
+// that need to be refreshed are requested anew and written to the volume atomically, unchanged volumes aren't
+// touched.
+func (p *p) refreshTokens(ctx context.Context) {
+	ticker := time.NewTicker(tokenResync)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for _, uid := range p.tokens.podsWithTokens() {
+			p.tokens.refresh(uid, func(pod *corev1.Pod) {
+				if _, err := p.volumes(pod, volumeProjected); err != nil {
+					log.Warnf("failed to refresh the projected volumes of pod %s/%s: %s", pod.Namespace, pod.Name, err)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package provider
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestTokenManager(t *testing.T) {
+	log = &noopLogger{}
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	requests := []*authenticationv1.TokenRequest{}
+	fail := false
+
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("create", "serviceaccounts", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if fail {
+			return true, nil, fmt.Errorf("unavailable")
+		}
+		tr := action.(k8stesting.CreateAction).GetObject().(*authenticationv1.TokenRequest).DeepCopy()
+		requests = append(requests, tr)
+		tr.Status.Token = fmt.Sprintf("token-%d", len(requests))
+		tr.Status.ExpirationTimestamp = metav1.NewTime(now.Add(time.Duration(*tr.Spec.ExpirationSeconds) * time.Second))
+		return true, tr, nil
+	})
+	tm := newTokenManager(client.CoreV1())
+	tm.now = func() time.Time { return now }
+
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "token", UID: "aa-bb"}}
+	pod.Spec.ServiceAccountName = "builder"
+	expiration := int64(1000)
+	source := &corev1.ServiceAccountTokenProjection{Audience: "vault", ExpirationSeconds: &expiration, Path: "token"}
+
+	token, err := tm.token(pod, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(token) != "token-1" {
+		t.Errorf("expected token-1, got %s", token)
+	}
+	req := requests[0]
+	if len(req.Spec.Audiences) != 1 || req.Spec.Audiences[0] != "vault" {
+		t.Errorf("expected audience vault, got %v", req.Spec.Audiences)
+	}
+	if ref := req.Spec.BoundObjectRef; ref == nil || ref.Kind != "Pod" || ref.UID != pod.UID {
+		t.Errorf("expected token to be bound to the pod, got %v", ref)
+	}
+
+	// Before 80% of the lifetime the cached token is returned.
+	now = now.Add(799 * time.Second)
+	if token, _ := tm.token(pod, source); string(token) != "token-1" || len(requests) != 1 {
+		t.Errorf("expected cached token-1, got %s after %d requests", token, len(requests))
+	}
+	// A failed refresh keeps the token that is still valid.
+	now = now.Add(2 * time.Second)
+	fail = true
+	if token, err := tm.token(pod, source); err != nil || string(token) != "token-1" {
+		t.Errorf("expected token-1 while the API is unavailable, got %s (%v)", token, err)
+	}
+	fail = false
+	if token, _ := tm.token(pod, source); string(token) != "token-2" {
+		t.Errorf("expected refreshed token-2, got %s", token)
+	}
+
+	// Only Pods with projected tokens are registered.
+	tm.register(pod)
+	if pods := tm.podsWithTokens(); len(pods) != 0 {
+		t.Errorf("expected no pods with tokens, got %d", len(pods))
+	}
+	pod.Spec.Volumes = []corev1.Volume{{Name: "token", VolumeSource: corev1.VolumeSource{Projected: &corev1.ProjectedVolumeSource{
+		Sources: []corev1.VolumeProjection{{ServiceAccountToken: source}},
+	}}}}
+	tm.register(pod)
+	if pods := tm.podsWithTokens(); len(pods) != 1 {
+		t.Errorf("expected 1 pod with tokens, got %d", len(pods))
+	}
+	tm.forget(pod)
+	if pods := tm.podsWithTokens(); len(pods) != 0 || len(tm.tokens) != 0 {
+		t.Errorf("expected pod and tokens to be forgotten, got %d pods and %d tokens", len(pods), len(tm.tokens))
+	}
+	// Requesting a token doesn't register the Pod again.
+	tm.token(pod, source)
+	if pods := tm.podsWithTokens(); len(pods) != 0 {
+		t.Errorf("expected no pods with tokens, got %d", len(pods))
+	}
+}
+
+func TestTokenManagerRefreshForget(t *testing.T) {
+	tm := newTokenManager(nil)
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "token", UID: "aa-bb"}}
+	pod.Spec.Volumes = []corev1.Volume{{Name: "token", VolumeSource: corev1.VolumeSource{Projected: &corev1.ProjectedVolumeSource{
+		Sources: []corev1.VolumeProjection{{ServiceAccountToken: &corev1.ServiceAccountTokenProjection{Path: "token"}}},
+	}}}}
+	tm.register(pod)
+
+	// forget waits for a refresh in progress.
+	refreshing, release, forgotten := make(chan struct{}), make(chan struct{}), make(chan struct{})
+	go tm.refresh(pod.UID, func(*corev1.Pod) {
+		close(refreshing)
+		<-release
+	})
+	<-refreshing
+	go func() {
+		tm.forget(pod)
+		close(forgotten)
+	}()
+	select {
+	case <-forgotten:
+		t.Fatal("expected forget to wait for the refresh")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	<-forgotten
+
+	// A forgotten Pod isn't refreshed.
+	tm.refresh(pod.UID, func(*corev1.Pod) {
+		t.Errorf("expected forgotten pod not to be refreshed")
+	})
+}
@@ -43,6 +43,7 @@ func (p *p) statsToPod(stats map[string]*unit.State) *corev1.Pod {
 		if err := p.unitManager.Unload(name); err != nil {
 			log.Error("failed to unload", err)
 		}
+		p.cache.remove(name)
 		p.unitManager.Reload()
 		return nil
 	}
@@ -57,7 +58,35 @@ func (p *p) statsToPod(stats map[string]*unit.State) *corev1.Pod {
 
 	containers, initContainers := p.toContainers(stats)
 	containerStatuses, initContainerStatuses := p.toContainerStatuses(stats)
-	starttime := metav1.NewTime(propertyTimestampToTime(p.unitManager.ServiceProperty(name, "ExecMainStartTimestamp")))
+	starttime := metav1.NewTime(propertyTimestampToTime(p.serviceProperty(name, "ExecMainStartTimestamp")))
+	policy := corev1.RestartPolicy(lastValue(uf, kubernetesSection, "RestartPolicy"))
+	phase := toPhase(initContainerStatuses, containerStatuses, policy)
+
+	initialized := corev1.ConditionFalse
+	if initContainersSucceeded(initContainerStatuses) {
+		initialized = corev1.ConditionTrue
+	}
+	ready := corev1.ConditionFalse
+	if initialized == corev1.ConditionTrue && containersReady(containerStatuses) {
+		ready = corev1.ConditionTrue
+	}
+	sandbox := corev1.ConditionFalse
+	qos := unitToQOS(uf)
+	if p.sandboxReady(qos, om.Namespace, om.Name) {
+		sandbox = corev1.ConditionTrue
+	}
+	conditions := p.conditions.update(om.UID, map[corev1.PodConditionType]corev1.ConditionStatus{
+		podReadyToStartContainers: sandbox,
+		corev1.PodInitialized:     initialized,
+		corev1.PodReady:           ready,
+		corev1.ContainersReady:    ready,
+		corev1.PodScheduled:       corev1.ConditionTrue,
+	}, metav1.Now())
+
+	message, reason := string(phase), ""
+	if msg, evicted := readEviction(om.UID); evicted {
+		phase, reason, message = corev1.PodFailed, evictedReason, msg
+	}
 
 	pod := &corev1.Pod{
 		TypeMeta: metav1.TypeMeta{
@@ -67,34 +96,21 @@ func (p *p) statsToPod(stats map[string]*unit.State) *corev1.Pod {
 		ObjectMeta: om,
 		Spec: corev1.PodSpec{
 			NodeName:       p.config.NodeName,
+			RestartPolicy:  policy,
 			Volumes:        []corev1.Volume{},
 			Containers:     containers,
 			InitContainers: initContainers,
 		},
 		Status: corev1.PodStatus{
-			HostIP: p.config.NodeInternalIP.String(),
-			PodIP:  p.config.NodeInternalIP.String(), // TODO(pires) this won't always be the case but works for now.
-			Phase:  toPhase(containerStatuses),       // might need to have pending if pulling done packages... etc?
-			Conditions: []corev1.PodCondition{
-				{
-					Type:               corev1.PodReady,
-					Status:             corev1.ConditionTrue,
-					LastTransitionTime: starttime,
-				},
-				{
-					Type:               corev1.PodInitialized,
-					Status:             corev1.ConditionTrue,
-					LastTransitionTime: starttime,
-				},
-				{
-					Type:               corev1.PodScheduled,
-					Status:             corev1.ConditionTrue,
-					LastTransitionTime: starttime,
-				},
-			},
+			HostIP:                p.config.NodeInternalIP.String(),
+			PodIP:                 p.config.NodeInternalIP.String(), // TODO(pires) this won't always be the case but works for now.
+			Phase:                 phase,
+			Conditions:            conditions,
 			ContainerStatuses:     containerStatuses,
 			InitContainerStatuses: initContainerStatuses,
-			Message:               string(toPhase(containerStatuses)),
+			Message:               message,
+			Reason:                reason,
+			QOSClass:              qos,
 			StartTime:             &starttime,
 		},
 	}
@@ -117,19 +133,8 @@ func (p *p) toContainers(stats map[string]*unit.State) ([]corev1.Container, []co
 			Name:      Container(k),
 			Image:     u.Contents[kubernetesSection]["Image"][0],
 			Command:   u.Contents["Service"]["ExecStart"],
-			Resources: v1.ResourceRequirements{
-				/*
-					Limits: v1.ResourceList{
-						v1.ResourceCPU:    resource.MustParse(fmt.Sprintf("%g", float64(c.CPU))),
-						v1.ResourceMemory: resource.MustParse(fmt.Sprintf("%dM", containerMemoryMB)),
-					},
-
-					Requests: v1.ResourceList{
-						v1.ResourceCPU:    resource.MustParse(fmt.Sprintf("%g", float64(c.CPU*1024/100))),
-						v1.ResourceMemory: resource.MustParse(fmt.Sprintf("%dM", containerMemoryMB)),
-					},
-				*/
-			},
+			Resources: unitToResources(u),
+			Ports:     unitToPorts(u),
 		}
 		if u.Contents[kubernetesSection]["InitContainer"] != nil {
 			initContainers = append(initContainers, container)
@@ -146,16 +151,28 @@ func (p *p) toContainerStatuses(stats map[string]*unit.State) ([]corev1.Containe
 	for _, k := range keys {
 		s := stats[k]
 		u, _ := unit.NewFile(s.UnitData)
-		restarts, _ := strconv.Atoi(p.unitManager.ServiceProperty(k, "NRestarts"))
+		// NRestarts only counts the automatic restarts, i.e. the ones due to Restart=.
+		restarts := propertyNumberToInt(p.serviceProperty(k, "NRestarts"))
+		state := p.containerState(s)
+		if w := unitWaiting(u); w != nil && s.ActiveState != "active" {
+			state = v1.ContainerState{Waiting: w}
+		}
+		// While waiting to be restarted, the last run is what terminated.
+		last := v1.ContainerState{}
+		if state.Waiting != nil && state.Waiting.Reason == crashLoopBackOff {
+			last.Terminated = p.terminatedState(k)
+		}
+		ready, started := p.prober.status(k, state.Running != nil)
 		status := v1.ContainerStatus{
 			Name:                 Container(k),
-			State:                p.containerState(s),
-			LastTerminationState: p.containerState(s),
-			Ready:                true, // readiness probes on the container level??
+			State:                state,
+			LastTerminationState: last,
+			Ready:                ready,
+			Started:              &started,
 			RestartCount:         int32(restarts),
 			Image:                u.Contents[kubernetesSection]["Image"][0],
-			ImageID:              hash(u.Contents[kubernetesSection]["Image"][0]),
-			ContainerID:          "pid://" + p.unitManager.ServiceProperty(k, "MainPID"),
+			ImageID:              imageID(u),
+			ContainerID:          "pid://" + p.serviceProperty(k, "MainPID"),
 		}
 		if u.Contents[kubernetesSection]["InitContainer"] != nil {
 			initStatuses = append(initStatuses, status)
@@ -163,49 +180,57 @@ func (p *p) toContainerStatuses(stats map[string]*unit.State) ([]corev1.Containe
 		}
 		statuses = append(statuses, status)
 	}
+	if !initContainersSucceeded(initStatuses) {
+		initializing(initStatuses)
+		initializing(statuses)
+	}
 	return statuses, initStatuses
 }
 
+// initializing marks the containers in status that haven't run yet as waiting for the Pod to be initialized. kubectl
+// then shows the Pod's progress as Init:N/M.
+func initializing(status []v1.ContainerStatus) {
+	for i, s := range status {
+		if s.State.Waiting == nil || s.State.Waiting.Reason == crashLoopBackOff {
+			continue
+		}
+		status[i].State.Waiting = &v1.ContainerStateWaiting{Reason: podInitializing}
+	}
+}
+
 func (p *p) containerState(u *unit.State) v1.ContainerState {
 	// systemctl --state=help
 	// Look at u.ActiveState at all?
 	switch {
+	case u.SubState == "auto-restart":
+		return v1.ContainerState{
+			Waiting: &v1.ContainerStateWaiting{
+				Reason:  crashLoopBackOff,
+				Message: "back-off restarting failed container",
+			},
+		}
+	case u.SubState == "failed" && p.serviceResult(u.Name) == "start-limit-hit":
+		return v1.ContainerState{
+			Waiting: &v1.ContainerStateWaiting{
+				Reason:  crashLoopBackOff,
+				Message: "start limit hit, not restarting failed container",
+			},
+		}
 	case strings.HasPrefix(u.SubState, "stop"):
 		fallthrough
 	case u.SubState == "failed" || u.SubState == "exited":
-		exitcode := int32(propertyNumberToInt(p.unitManager.ServiceProperty(u.Name, "ExecMainStatus")))
-		reason := string(corev1.PodFailed)
-		if exitcode == 0 {
-			reason = string(corev1.PodSucceeded)
-		}
+		return v1.ContainerState{Terminated: p.terminatedState(u.Name)}
+	case u.SubState == "start" && propertyNumberToInt(p.serviceProperty(u.Name, "MainPID")) > 0:
+		// A oneshot (init container) stays in start while it runs.
 		return v1.ContainerState{
-			Terminated: &v1.ContainerStateTerminated{
-				ExitCode:    exitcode,
-				Reason:      reason,
-				Message:     reason,
-				StartedAt:   metav1.NewTime(propertyTimestampToTime(p.unitManager.ServiceProperty(u.Name, "ExecMainStartTimestamp"))),
-				FinishedAt:  metav1.NewTime(propertyTimestampToTime(p.unitManager.ServiceProperty(u.Name, "ExecMainExitTimestamp"))),
-				ContainerID: "pid://" + p.unitManager.ServiceProperty(u.Name, "MainPID"),
+			Running: &v1.ContainerStateRunning{
+				StartedAt: metav1.NewTime(propertyTimestampToTime(p.serviceProperty(u.Name, "ExecMainStartTimestamp"))),
 			},
 		}
 	case u.SubState == "dead": // either ran, or waiting to be run
-		exitStamp := propertyNumberToInt(p.unitManager.ServiceProperty(u.Name, "ExecMainExitTimestamp"))
+		exitStamp := propertyNumberToInt(p.serviceProperty(u.Name, "ExecMainExitTimestamp"))
 		if exitStamp > 0 {
-			exitcode := int32(propertyNumberToInt(p.unitManager.ServiceProperty(u.Name, "ExecMainStatus")))
-			reason := string(corev1.PodFailed)
-			if exitcode == 0 {
-				reason = string(corev1.PodSucceeded)
-			}
-			return v1.ContainerState{
-				Terminated: &v1.ContainerStateTerminated{
-					ExitCode:    exitcode,
-					Reason:      reason,
-					Message:     reason,
-					StartedAt:   metav1.NewTime(propertyTimestampToTime(p.unitManager.ServiceProperty(u.Name, "ExecMainStartTimestamp"))),
-					FinishedAt:  metav1.NewTime(propertyTimestampToTime(p.unitManager.ServiceProperty(u.Name, "ExecMainExitTimestamp"))),
-					ContainerID: "pid://" + p.unitManager.ServiceProperty(u.Name, "MainPID"),
-				},
-			}
+			return v1.ContainerState{Terminated: p.terminatedState(u.Name)}
 		}
 		fallthrough // fall to condition waiting
 	case strings.HasPrefix(u.SubState, "start"):
@@ -217,10 +242,10 @@ func (p *p) containerState(u *unit.State) v1.ContainerState {
 				Message: u.SubState,
 			},
 		}
-	case u.SubState == "running" || u.SubState == "auto-restart" || u.SubState == "reload":
+	case u.SubState == "running" || u.SubState == "reload":
 		return v1.ContainerState{
 			Running: &v1.ContainerStateRunning{
-				StartedAt: metav1.NewTime(propertyTimestampToTime(p.unitManager.ServiceProperty(u.Name, "ExecMainStartTimestamp"))),
+				StartedAt: metav1.NewTime(propertyTimestampToTime(p.serviceProperty(u.Name, "ExecMainStartTimestamp"))),
 			},
 		}
 
@@ -230,38 +255,117 @@ func (p *p) containerState(u *unit.State) v1.ContainerState {
 	}
 }
 
-func toPhase(status []v1.ContainerStatus) corev1.PodPhase {
-	// run through the states, if 1 is waiting return pending.
-	running := 0
-	terminated := 0
-	exitcode := int32(0)
-	for _, s := range status {
-		s1 := s.State
-		if s1.Waiting != nil {
-			return corev1.PodPending
+// terminatedState returns the terminated state of the last run of the unit name.
+func (p *p) terminatedState(name string) *v1.ContainerStateTerminated {
+	exitcode := int32(propertyNumberToInt(p.serviceProperty(name, "ExecMainStatus")))
+	// The same reasons as the kubelet's, kubectl shows them (e.g. as Init:Error).
+	reason := "Error"
+	if exitcode == 0 {
+		reason = "Completed"
+	}
+	return &v1.ContainerStateTerminated{
+		ExitCode:    exitcode,
+		Reason:      reason,
+		Message:     reason,
+		StartedAt:   metav1.NewTime(propertyTimestampToTime(p.serviceProperty(name, "ExecMainStartTimestamp"))),
+		FinishedAt:  metav1.NewTime(propertyTimestampToTime(p.serviceProperty(name, "ExecMainExitTimestamp"))),
+		ContainerID: "pid://" + p.serviceProperty(name, "MainPID"),
+	}
+}
+
+// serviceResult returns the Result property of the unit name, i.e. why it last stopped.
+func (p *p) serviceResult(name string) string {
+	return strings.Trim(p.serviceProperty(name, "Result"), `"`)
+}
+
+// toPhase returns the Pod's phase, following the kubelet's rules:
+//
+// * Pending while the init containers haven't all succeeded, or when a container is waiting for the first time;
+// * Failed when an init container failed and the Pod doesn't restart it;
+// * Running while a container is running, or when the containers stopped but (some) will be restarted;
+// * Succeeded when all containers stopped successfully, and they won't be restarted;
+// * Failed when all containers stopped, some unsuccessfully, and they won't be restarted.
+func toPhase(initStatus, status []v1.ContainerStatus, policy corev1.RestartPolicy) corev1.PodPhase {
+	pendingInit, failedInit := 0, false
+	for _, s := range initStatus {
+		switch {
+		case s.State.Terminated != nil && s.State.Terminated.ExitCode == 0:
+		case s.State.Terminated != nil:
+			failedInit = true
+		case s.LastTerminationState.Terminated != nil && s.LastTerminationState.Terminated.ExitCode != 0:
+			failedInit = true
+		default:
+			pendingInit++
 		}
-		if s1.Running != nil {
+	}
+	if failedInit && policy == corev1.RestartPolicyNever {
+		return corev1.PodFailed
+	}
+	if failedInit || pendingInit > 0 {
+		return corev1.PodPending
+	}
+
+	running, waiting, stopped, succeeded := 0, 0, 0, 0
+	for _, s := range status {
+		switch {
+		case s.State.Running != nil:
 			running++
-		}
-		if s1.Terminated != nil {
-			terminated++
-			exitcode += s1.Terminated.ExitCode
+		case s.State.Terminated != nil:
+			stopped++
+			if s.State.Terminated.ExitCode == 0 {
+				succeeded++
+			}
+		case s.State.Waiting != nil && s.LastTerminationState.Terminated != nil:
+			// Waiting to be restarted.
+			stopped++
+		default:
+			waiting++
 		}
 	}
 
-	if running == len(status) { // all running
+	switch {
+	case len(status) == 0:
+		return corev1.PodPending
+	case waiting > 0:
+		return corev1.PodPending
+	case running > 0:
 		return corev1.PodRunning
-	}
-
-	if terminated == len(status) {
-		if exitcode == 0 {
+	case policy == corev1.RestartPolicyNever:
+		if succeeded == stopped {
 			return corev1.PodSucceeded
-
 		}
 		return corev1.PodFailed
+	case policy == corev1.RestartPolicyOnFailure:
+		if succeeded == stopped {
+			return corev1.PodSucceeded
+		}
+		return corev1.PodRunning
+	default: // Always
+		return corev1.PodRunning
+	}
+}
+
+// initContainersSucceeded returns true if all init containers exited successfully.
+func initContainersSucceeded(status []v1.ContainerStatus) bool {
+	for _, s := range status {
+		if s.State.Terminated == nil || s.State.Terminated.ExitCode != 0 {
+			return false
+		}
 	}
+	return true
+}
 
-	return corev1.PodUnknown
+// containersReady returns true if there are containers and all of them are ready.
+func containersReady(status []v1.ContainerStatus) bool {
+	if len(status) == 0 {
+		return false
+	}
+	for _, s := range status {
+		if !s.Ready {
+			return false
+		}
+	}
+	return true
 }
 
 func unitNames(units map[string]*unit.State) []string {
@@ -285,8 +389,8 @@ ExecStart=
 WantedBy=multi-user.target
 `
 
-func (p *p) unitfileFromPackageOrSynthesized(c corev1.Container) (*unit.File, error) {
-	u, err := p.pkgManager.Unitfile(c.Image)
+func (p *p) unitfileFromPackageOrSynthesized(pkg string) (*unit.File, error) {
+	u, err := p.pkgManager.Unitfile(pkg)
 	if err != nil {
 		log.Warnf("failed to find unit file, synthesizing one")
 		uf, err := unit.NewFile(synthUnit)
@@ -307,6 +411,15 @@ func (p *p) unitfileFromPackageOrSynthesized(c corev1.Container) (*unit.File, er
 
 const kubernetesSection = "X-Kubernetes"
 
+// imageID returns the installed package and its version as recorded in the unit, or a hash of the image if the
+// version isn't known.
+func imageID(u *unit.File) string {
+	if id := u.Contents[kubernetesSection]["ImageID"]; len(id) > 0 {
+		return id[0]
+	}
+	return hash(u.Contents[kubernetesSection]["Image"][0])
+}
+
 func hash(s string) string {
 	h := sha1.New()
 	h.Write([]byte(s))
@@ -0,0 +1,59 @@
+package provider
+
+import (
+	"testing"
+
+	"github.com/coreos/go-systemd/v22/dbus"
+	"github.com/virtual-kubelet/systemk/internal/unit"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestToPhase(t *testing.T) {
+	running := corev1.ContainerStatus{State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}}
+	succeeded := corev1.ContainerStatus{State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{}}}
+	failed := corev1.ContainerStatus{State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 1}}}
+	waiting := corev1.ContainerStatus{State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{}}}
+	backoff := corev1.ContainerStatus{
+		State:                corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: crashLoopBackOff}},
+		LastTerminationState: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 1}},
+	}
+
+	tests := []struct {
+		init   []corev1.ContainerStatus
+		status []corev1.ContainerStatus
+		policy corev1.RestartPolicy
+		phase  corev1.PodPhase
+	}{
+		{nil, []corev1.ContainerStatus{running, succeeded}, corev1.RestartPolicyAlways, corev1.PodRunning},
+		{nil, []corev1.ContainerStatus{running, waiting}, corev1.RestartPolicyAlways, corev1.PodPending},
+		{nil, []corev1.ContainerStatus{backoff}, corev1.RestartPolicyAlways, corev1.PodRunning},
+		{nil, []corev1.ContainerStatus{succeeded, failed}, corev1.RestartPolicyAlways, corev1.PodRunning},
+		{nil, []corev1.ContainerStatus{succeeded, succeeded}, corev1.RestartPolicyOnFailure, corev1.PodSucceeded},
+		{nil, []corev1.ContainerStatus{succeeded, failed}, corev1.RestartPolicyOnFailure, corev1.PodRunning},
+		{nil, []corev1.ContainerStatus{succeeded, failed}, corev1.RestartPolicyNever, corev1.PodFailed},
+		{nil, []corev1.ContainerStatus{succeeded}, corev1.RestartPolicyNever, corev1.PodSucceeded},
+		{[]corev1.ContainerStatus{running}, []corev1.ContainerStatus{waiting}, corev1.RestartPolicyAlways, corev1.PodPending},
+		{[]corev1.ContainerStatus{failed}, []corev1.ContainerStatus{waiting}, corev1.RestartPolicyNever, corev1.PodFailed},
+		{[]corev1.ContainerStatus{failed}, []corev1.ContainerStatus{waiting}, corev1.RestartPolicyOnFailure, corev1.PodPending},
+		{[]corev1.ContainerStatus{succeeded}, []corev1.ContainerStatus{running}, corev1.RestartPolicyNever, corev1.PodRunning},
+	}
+	for i, tc := range tests {
+		if phase := toPhase(tc.init, tc.status, tc.policy); phase != tc.phase {
+			t.Errorf("test %d, expected phase %s, got %s", i, tc.phase, phase)
+		}
+	}
+}
+
+func TestContainerStateNeverRan(t *testing.T) {
+	log = &noopLogger{}
+	p := new(p)
+	p.unitManager, _ = unit.NewMockManager()
+
+	// The mock has no ExecMainExitTimestamp nor MainPID, i.e. the units never ran.
+	for _, sub := range []string{"dead", "start"} {
+		state := p.containerState(&unit.State{UnitStatus: dbus.UnitStatus{Name: "systemk.default.pod.a.service", SubState: sub}})
+		if state.Waiting == nil || state.Running != nil {
+			t.Errorf("substate %s, expected container to be waiting, got %v", sub, state)
+		}
+	}
+}
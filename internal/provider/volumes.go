@@ -10,7 +10,6 @@ import (
 
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
-	"k8s.io/apimachinery/pkg/labels"
 )
 
 const (
@@ -18,6 +17,8 @@ const (
 	emptyDir     = "emptydirs"
 	secretDir    = "secrets"
 	configmapDir = "configmaps"
+	downwardDir  = "downwardapis"
+	projectedDir = "projected"
 )
 
 // Volume describes what volumes should be created.
@@ -27,6 +28,7 @@ const (
 	volumeAll Volume = iota
 	volumeConfigMap
 	volumeSecret
+	volumeProjected
 )
 
 // volumes inspects the PodSpec.Volumes attribute and returns a mapping with the volume's Name and the directory on-disk that
@@ -38,6 +40,7 @@ func (p *p) volumes(pod *corev1.Pod, which Volume) (map[string]string, error) {
 		WithField("podName", pod.Name)
 
 	vol := make(map[string]string)
+	limits := []emptyDirLimit{}
 	uid, gid, err := uidGidFromSecurityContext(pod, p.config.OverrideRootUID)
 	if err != nil {
 		return nil, err
@@ -50,8 +53,8 @@ func (p *p) volumes(pod *corev1.Pod, which Volume) (map[string]string, error) {
 				continue
 			}
 
-			// v.Path should exist and be usuable by this pod. No checks are done here.
-			vol[v.Name] = ""
+			// The path is checked against the volume's type by podUnits, for the containers that mount it.
+			vol[v.Name] = v.HostPath.Path
 
 		case v.EmptyDir != nil:
 			if which != volumeAll {
@@ -62,6 +65,18 @@ func (p *p) volumes(pod *corev1.Pod, which Volume) (map[string]string, error) {
 				return nil, err
 			}
 			fnlog.Debugf("created %q for emptyDir %q", dir, v.Name)
+			switch v.EmptyDir.Medium {
+			case corev1.StorageMediumDefault:
+				if v.EmptyDir.SizeLimit != nil {
+					limits = append(limits, emptyDirLimit{Name: v.Name, Path: dir, SizeLimit: *v.EmptyDir.SizeLimit})
+				}
+			case corev1.StorageMediumMemory:
+				if err := mountTmpfs(dir, p.tmpfsSize(pod, v.EmptyDir), uid, gid); err != nil {
+					return nil, fmt.Errorf("failed to mount tmpfs for emptyDir %s: %s", v.Name, err)
+				}
+			default:
+				return nil, fmt.Errorf("pod %s requires emptyDir %s with unsupported medium %s", pod.Name, v.Name, v.EmptyDir.Medium)
+			}
 			vol[v.Name] = dir
 
 		case v.Secret != nil:
@@ -82,19 +97,12 @@ func (p *p) volumes(pod *corev1.Pod, which Volume) (map[string]string, error) {
 			}
 			fnlog.Debugf("created %q for secret %q", dir, v.Name)
 
-			for k, v := range secret.StringData {
-				data, err := base64.StdEncoding.DecodeString(string(v))
-				if err != nil {
-					return nil, err
-				}
-				if err := writeFile(dir, k, uid, gid, data); err != nil {
-					return nil, err
-				}
+			payload, err := secretPayload(secret, v.Secret.Items, v.Secret.DefaultMode, isOptional(v.Secret.Optional))
+			if err != nil {
+				return nil, err
 			}
-			for k, v := range secret.Data {
-				if err := writeFile(dir, k, uid, gid, []byte(v)); err != nil {
-					return nil, err
-				}
+			if err := writeAtomic(dir, payload, uid, gid); err != nil {
+				return nil, err
 			}
 			vol[v.Name] = dir
 
@@ -116,144 +124,205 @@ func (p *p) volumes(pod *corev1.Pod, which Volume) (map[string]string, error) {
 			}
 			fnlog.Debugf("created %q for configmap %q", dir, v.Name)
 
-			for k, v := range configMap.Data {
-				if err := writeFile(dir, k, uid, gid, []byte(v)); err != nil {
-					return nil, err
-				}
+			payload, err := configMapPayload(configMap, v.ConfigMap.Items, v.ConfigMap.DefaultMode, isOptional(v.ConfigMap.Optional))
+			if err != nil {
+				return nil, err
 			}
-			for k, v := range configMap.BinaryData {
-				if err := writeFile(dir, k, uid, gid, v); err != nil {
-					return nil, err
-				}
+			if err := writeAtomic(dir, payload, uid, gid); err != nil {
+				return nil, err
 			}
 			vol[v.Name] = dir
 
-		case v.Projected != nil:
-			for _, source := range v.Projected.Sources {
-				switch {
-				case source.ServiceAccountToken != nil:
-					// This is still stored in a secret, hence the dance to figure out what secret.
-					secrets, err := p.podResourceManager.SecretLister().Secrets(pod.Namespace).List(labels.Everything())
-					if err != nil {
-						return nil, err
-					}
-				Secrets:
-					for _, secret := range secrets {
-						if secret.Type != corev1.SecretTypeServiceAccountToken {
-							continue
-						}
-						// annotation now needs to match the pod.ServiceAccountName
-						for k, a := range secret.ObjectMeta.Annotations {
-							if k == "kubernetes.io/service-account.name" && a == pod.Spec.ServiceAccountName {
-								// this is the secret we're after. Now the projected service account has a path element, which is the only path
-								// we want from this secret, but it could still be in StringData or Data
-								dir, err := p.setupPaths(pod, secretDir, i)
-								if err != nil {
-									return nil, err
-								}
-								fnlog.Debugf("created %q for projected serviceAccountToken (secret) %q", dir, v.Name)
-
-								for k, v := range secret.StringData {
-									data, err := base64.StdEncoding.DecodeString(string(v))
-									if err != nil {
-										return nil, err
-									}
-									if err := writeFile(dir, k, uid, gid, data); err != nil {
-										return nil, err
-									}
-								}
-								for k, v := range secret.Data {
-									if err := writeFile(dir, k, uid, gid, []byte(v)); err != nil {
-										return nil, err
-									}
-								}
-								vol[v.Name] = dir
-								break Secrets
-							}
-						}
-					}
-
-				case source.Secret != nil:
-					secret, err := p.podResourceManager.SecretLister().Secrets(pod.Namespace).Get(source.Secret.Name)
-					if source.Secret.Optional != nil && !*source.Secret.Optional && errors.IsNotFound(err) {
-						return nil, fmt.Errorf("projected secret %s is required by pod %s and does not exist", source.Secret.Name, pod.Name)
-					}
-					if secret == nil {
-						continue
-					}
-
-					dir, err := p.setupPaths(pod, secretDir, i)
-					if err != nil {
-						return nil, err
-					}
-					fnlog.Debugf("created %q for projected secret %q", dir, v.Name)
-
-					for _, keyToPath := range source.ConfigMap.Items {
-						for k, v := range secret.StringData {
-							if keyToPath.Key == k {
-								data, err := base64.StdEncoding.DecodeString(string(v))
-								if err != nil {
-									return nil, err
-								}
-								if err := writeFile(dir, keyToPath.Path, uid, gid, data); err != nil {
-									return nil, err
-								}
-							}
-						}
-						for k, v := range secret.Data {
-							if keyToPath.Key == k {
-								if err := writeFile(dir, keyToPath.Path, uid, gid, []byte(v)); err != nil {
-									return nil, err
-								}
-							}
-						}
-					}
-					vol[v.Name] = dir
-
-				case source.ConfigMap != nil:
-					configMap, err := p.podResourceManager.ConfigMapLister().ConfigMaps(pod.Namespace).Get(source.ConfigMap.Name)
-					if source.ConfigMap.Optional != nil && !*source.ConfigMap.Optional && errors.IsNotFound(err) {
-						return nil, fmt.Errorf("projected configMap %s is required by pod %s and does not exist", source.ConfigMap.Name, pod.Name)
-					}
-					if configMap == nil {
-						continue
-					}
-
-					dir, err := p.setupPaths(pod, configmapDir, i)
-					if err != nil {
-						return nil, err
-					}
-					fnlog.Debugf("created %q for projected configmap %q", dir, v.Name)
-
-					for _, keyToPath := range source.ConfigMap.Items {
-						for k, v := range configMap.Data {
-							if keyToPath.Key == k {
-								if err := writeFile(dir, keyToPath.Path, uid, gid, []byte(v)); err != nil {
-									return nil, err
-								}
-							}
-						}
-						for k, v := range configMap.BinaryData {
-							if keyToPath.Key == k {
-								if err := writeFile(dir, keyToPath.Path, uid, gid, v); err != nil {
-									return nil, err
-								}
-							}
-						}
-					}
-					vol[v.Name] = dir
+		case v.DownwardAPI != nil:
+			// Also rewritten by UpdatePod, so that changes to the Pod's labels and annotations show up.
+			if which != volumeAll {
+				continue
+			}
+			dir, err := p.setupPaths(pod, downwardDir, i)
+			if err != nil {
+				return nil, err
+			}
+			fnlog.Debugf("created %q for downwardAPI %q", dir, v.Name)
+			payload, err := p.downwardAPIPayload(pod, v.DownwardAPI.Items, v.DownwardAPI.DefaultMode)
+			if err != nil {
+				return nil, err
+			}
+			if err := writeAtomic(dir, payload, uid, gid); err != nil {
+				return nil, err
+			}
+			vol[v.Name] = dir
 
-				}
+		case v.Projected != nil:
+			// Also rewritten by UpdateConfigMap, UpdateSecret, UpdatePod and refreshTokens, as any of the sources may
+			// change.
+			dir, err := p.setupPaths(pod, projectedDir, i)
+			if err != nil {
+				return nil, err
 			}
+			fnlog.Debugf("created %q for projected %q", dir, v.Name)
+			payload, err := p.projectedPayload(pod, v.Projected)
+			if err != nil {
+				return nil, fmt.Errorf("projected volume %s: %s", v.Name, err)
+			}
+			if err := writeAtomic(dir, payload, uid, gid); err != nil {
+				return nil, err
+			}
+			vol[v.Name] = dir
 
 		default:
 			return nil, fmt.Errorf("pod %s requires volume %s which is of an unsupported type", pod.Name, v.Name)
 		}
 	}
 
+	if which == volumeAll {
+		if err := writeEmptyDirLimits(pod, limits); err != nil {
+			return nil, err
+		}
+	}
 	return vol, nil
 }
 
+// downwardAPIPayload returns the files of the downward API items, see writeAtomic.
+func (p *p) downwardAPIPayload(pod *corev1.Pod, items []corev1.DownwardAPIVolumeFile, defaultMode *int32) (map[string]fileProjection, error) {
+	payload := map[string]fileProjection{}
+	for _, item := range items {
+		var (
+			value string
+			err   error
+		)
+		switch {
+		case item.FieldRef != nil:
+			value, err = p.fieldPathValue(pod, item.FieldRef.FieldPath)
+		case item.ResourceFieldRef != nil:
+			// The container must be named in a volume, there is no container to default to.
+			value, err = containerResourceValue(pod, corev1.Container{}, item.ResourceFieldRef)
+		default:
+			err = fmt.Errorf("no fieldRef or resourceFieldRef")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("downwardAPI item %s: %s", item.Path, err)
+		}
+		payload[item.Path] = fileProjection{data: []byte(value), mode: fileMode(item.Mode, defaultMode, corev1.DownwardAPIVolumeSourceDefaultMode)}
+	}
+	return payload, nil
+}
+
+// projectedPayload returns the files of all sources of the projected volume, merged into one volume as the kubelet
+// does: the sources are added in order and a path that is in more than one source gets the contents of the last.
+// A missing ConfigMap, Secret or key is an error, unless it's optional.
+func (p *p) projectedPayload(pod *corev1.Pod, projected *corev1.ProjectedVolumeSource) (map[string]fileProjection, error) {
+	payload := map[string]fileProjection{}
+	for _, source := range projected.Sources {
+		var (
+			files map[string]fileProjection
+			err   error
+		)
+		switch {
+		case source.ServiceAccountToken != nil:
+			var token []byte
+			token, err = p.tokens.token(pod, source.ServiceAccountToken)
+			files = map[string]fileProjection{
+				source.ServiceAccountToken.Path: {data: token, mode: fileMode(nil, projected.DefaultMode, corev1.ProjectedVolumeSourceDefaultMode)},
+			}
+
+		case source.Secret != nil:
+			secret, getErr := p.podResourceManager.SecretLister().Secrets(pod.Namespace).Get(source.Secret.Name)
+			if getErr != nil {
+				if errors.IsNotFound(getErr) && isOptional(source.Secret.Optional) {
+					continue
+				}
+				return nil, fmt.Errorf("secret %s: %s", source.Secret.Name, getErr)
+			}
+			files, err = secretPayload(secret, source.Secret.Items, projected.DefaultMode, isOptional(source.Secret.Optional))
+
+		case source.ConfigMap != nil:
+			configMap, getErr := p.podResourceManager.ConfigMapLister().ConfigMaps(pod.Namespace).Get(source.ConfigMap.Name)
+			if getErr != nil {
+				if errors.IsNotFound(getErr) && isOptional(source.ConfigMap.Optional) {
+					continue
+				}
+				return nil, fmt.Errorf("configMap %s: %s", source.ConfigMap.Name, getErr)
+			}
+			files, err = configMapPayload(configMap, source.ConfigMap.Items, projected.DefaultMode, isOptional(source.ConfigMap.Optional))
+
+		case source.DownwardAPI != nil:
+			files, err = p.downwardAPIPayload(pod, source.DownwardAPI.Items, projected.DefaultMode)
+		}
+		if err != nil {
+			return nil, err
+		}
+		for path, f := range files {
+			if _, ok := payload[path]; ok {
+				log.Warnf("pod %s/%s has conflicting projected path %q, the last source wins", pod.Namespace, pod.Name, path)
+			}
+			payload[path] = f
+		}
+	}
+	return payload, nil
+}
+
+// configMapPayload returns the files of configMap's keys, or only those of items, see keysPayload.
+func configMapPayload(configMap *corev1.ConfigMap, items []corev1.KeyToPath, defaultMode *int32, optional bool) (map[string]fileProjection, error) {
+	data := map[string][]byte{}
+	for k, v := range configMap.Data {
+		data[k] = []byte(v)
+	}
+	for k, v := range configMap.BinaryData {
+		data[k] = v
+	}
+	return keysPayload("configMap "+configMap.Name, data, items, fileMode(nil, defaultMode, corev1.ConfigMapVolumeSourceDefaultMode), optional)
+}
+
+// secretPayload returns the files of secret's keys, or only those of items, see keysPayload.
+func secretPayload(secret *corev1.Secret, items []corev1.KeyToPath, defaultMode *int32, optional bool) (map[string]fileProjection, error) {
+	data := map[string][]byte{}
+	for k, v := range secret.StringData {
+		d, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			return nil, err
+		}
+		data[k] = d
+	}
+	for k, v := range secret.Data {
+		data[k] = v
+	}
+	return keysPayload("secret "+secret.Name, data, items, fileMode(nil, defaultMode, corev1.SecretVolumeSourceDefaultMode), optional)
+}
+
+// keysPayload returns a file for each key in data, named after the key and with mode. If items are given only those
+// keys are returned, with the item's path and mode; a key that doesn't exist is an error, unless optional is true.
+func keysPayload(what string, data map[string][]byte, items []corev1.KeyToPath, mode int32, optional bool) (map[string]fileProjection, error) {
+	payload := map[string]fileProjection{}
+	if len(items) == 0 {
+		for k, v := range data {
+			payload[k] = fileProjection{data: v, mode: mode}
+		}
+		return payload, nil
+	}
+	for _, item := range items {
+		v, ok := data[item.Key]
+		if !ok {
+			if optional {
+				continue
+			}
+			return nil, fmt.Errorf("%s references non-existent key %s", what, item.Key)
+		}
+		payload[item.Path] = fileProjection{data: v, mode: fileMode(item.Mode, nil, mode)}
+	}
+	return payload, nil
+}
+
+// fileMode returns mode if set, otherwise defaultMode if set, otherwise def.
+func fileMode(mode, defaultMode *int32, def int32) int32 {
+	if mode != nil {
+		return *mode
+	}
+	if defaultMode != nil {
+		return *defaultMode
+	}
+	return def
+}
+
 // mkdirAllChown calls os.MkdirAll and chown to create path and set ownership.
 func mkdirAllChown(path string, perm os.FileMode, uid, gid string) error {
 	if err := os.MkdirAll(path, perm); err != nil {
@@ -310,6 +379,10 @@ func chown(name, uid, gid string) error {
 }
 
 func cleanPodEphemeralVolumes(podId string) error {
+	unmountEmptyDirs(podId)
+	if err := unmountSubPaths(podId); err != nil {
+		return err
+	}
 	podEphemeralVolumes := filepath.Join(varrun, podId)
 	return os.RemoveAll(podEphemeralVolumes)
 }
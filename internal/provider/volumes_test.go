@@ -1,8 +1,16 @@
 package provider
 
 import (
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
+
+	"github.com/virtual-kubelet/systemk/internal/kubernetes"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
 )
 
 func TestMkdirAll(t *testing.T) {
@@ -23,3 +31,140 @@ func TestMkdirAll(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestDownwardAPIPayload(t *testing.T) {
+	p := new(p)
+	log = &noopLogger{}
+	p.config = &Opts{NodeName: "node"}
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Namespace: "default", Name: "downward", UID: "aa-bb",
+		Labels: map[string]string{"app": "downward", "tier": "backend"},
+	}}
+	pod.Spec.Containers = []corev1.Container{{
+		Name:      "c",
+		Resources: corev1.ResourceRequirements{Limits: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("500m")}},
+	}}
+	mode := int32(0600)
+	items := []corev1.DownwardAPIVolumeFile{
+		{Path: "labels", FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.labels"}},
+		{Path: "meta/name", FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.name"}, Mode: &mode},
+		{Path: "node", FieldRef: &corev1.ObjectFieldSelector{FieldPath: "spec.nodeName"}},
+		{Path: "cpu", ResourceFieldRef: &corev1.ResourceFieldSelector{ContainerName: "c", Resource: "limits.cpu", Divisor: resource.MustParse("1m")}},
+	}
+	dir := t.TempDir()
+	payload, err := p.downwardAPIPayload(pod, items, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeAtomic(dir, payload, "", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	expect := map[string]string{
+		"labels":    "app=\"downward\"\ntier=\"backend\"\n",
+		"meta/name": "downward",
+		"node":      "node",
+		"cpu":       "500",
+	}
+	for path, v := range expect {
+		buf, err := ioutil.ReadFile(filepath.Join(dir, path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(buf) != v {
+			t.Errorf("expected %s to contain %q, got %q", path, v, buf)
+		}
+	}
+	if fi, err := os.Stat(filepath.Join(dir, "meta/name")); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("expected meta/name to have mode 0600, got %v (%v)", fi.Mode().Perm(), err)
+	}
+}
+
+func TestKeysPayload(t *testing.T) {
+	data := map[string][]byte{"a": []byte("1"), "b": []byte("2")}
+	mode := int32(0400)
+
+	payload, err := keysPayload("configMap test", data, nil, 0644, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(payload) != 2 || payload["a"].mode != 0644 {
+		t.Errorf("expected all keys with the default mode, got %v", payload)
+	}
+
+	items := []corev1.KeyToPath{{Key: "a", Path: "dir/a.conf", Mode: &mode}, {Key: "b", Path: "b.conf"}}
+	payload, err = keysPayload("configMap test", data, items, 0644, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(payload) != 2 || payload["dir/a.conf"].mode != 0400 || payload["b.conf"].mode != 0644 {
+		t.Errorf("expected only the items with their modes, got %v", payload)
+	}
+
+	items = []corev1.KeyToPath{{Key: "c", Path: "c"}}
+	if _, err := keysPayload("configMap test", data, items, 0644, false); err == nil {
+		t.Errorf("expected error for a missing key")
+	}
+	if payload, err := keysPayload("configMap test", data, items, 0644, true); err != nil || len(payload) != 0 {
+		t.Errorf("expected no files and no error for a missing optional key, got %v, %v", payload, err)
+	}
+}
+
+func TestProjectedPayload(t *testing.T) {
+	log = &noopLogger{}
+	factory := informers.NewSharedInformerFactory(nil, 0)
+	factory.Core().V1().ConfigMaps().Informer().GetIndexer().Add(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "kube-root-ca.crt"},
+		Data:       map[string]string{"ca.crt": "ca", "other": "x"},
+	})
+	factory.Core().V1().Secrets().Informer().GetIndexer().Add(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "creds"},
+		Data:       map[string][]byte{"user": []byte("admin"), "password": []byte("secret")},
+	})
+	p := new(p)
+	p.config = &Opts{NodeName: "node"}
+	p.podResourceManager = kubernetes.NewPodResourceWatcher(factory)
+
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "projected"}}
+	mode := int32(0400)
+	projected := &corev1.ProjectedVolumeSource{
+		DefaultMode: &mode,
+		Sources: []corev1.VolumeProjection{
+			{ConfigMap: &corev1.ConfigMapProjection{
+				LocalObjectReference: corev1.LocalObjectReference{Name: "kube-root-ca.crt"},
+				Items:                []corev1.KeyToPath{{Key: "ca.crt", Path: "ca.crt"}},
+			}},
+			{Secret: &corev1.SecretProjection{LocalObjectReference: corev1.LocalObjectReference{Name: "creds"}}},
+			{DownwardAPI: &corev1.DownwardAPIProjection{Items: []corev1.DownwardAPIVolumeFile{
+				{Path: "namespace", FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.namespace"}},
+				// Conflicts with the secret's key, the last source wins.
+				{Path: "user", FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.name"}},
+			}}},
+		},
+	}
+	payload, err := p.projectedPayload(pod, projected)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := map[string]string{"ca.crt": "ca", "password": "secret", "namespace": "default", "user": "projected"}
+	if len(payload) != len(expect) {
+		t.Errorf("expected %d files, got %d", len(expect), len(payload))
+	}
+	for path, v := range expect {
+		if f := payload[path]; string(f.data) != v || f.mode != mode {
+			t.Errorf("expected %s to contain %q with mode %o, got %q with mode %o", path, v, mode, f.data, f.mode)
+		}
+	}
+
+	projected.Sources = append(projected.Sources, corev1.VolumeProjection{
+		Secret: &corev1.SecretProjection{LocalObjectReference: corev1.LocalObjectReference{Name: "missing"}},
+	})
+	if _, err := p.projectedPayload(pod, projected); err == nil {
+		t.Errorf("expected error for a missing secret")
+	}
+	optional := true
+	projected.Sources[len(projected.Sources)-1].Secret.Optional = &optional
+	if _, err := p.projectedPayload(pod, projected); err != nil {
+		t.Errorf("expected no error for a missing optional secret, got %s", err)
+	}
+}
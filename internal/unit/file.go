@@ -136,6 +136,8 @@ func DefaultUnitType(name string) string {
 const (
 	// ServiceSuffix is the suffix for service files. This includes the dot.
 	ServiceSuffix = ".service"
+	// SliceSuffix is the suffix for slice files. This includes the dot.
+	SliceSuffix = ".slice"
 )
 
 // State encodes the current state of a unit loaded into a systemk agent
@@ -17,12 +17,14 @@
 package unit
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path"
 	"strings"
 	"sync"
+	"syscall"
 
 	"github.com/coreos/go-systemd/v22/dbus"
 	vklogv2 "github.com/virtual-kubelet/virtual-kubelet/log/klogv2"
@@ -34,6 +36,7 @@ var log = vklogv2.New(nil)
 type Manager interface {
 	// this is an interface mostly for testing.
 	Disable(name string) error
+	Kill(name string, signal syscall.Signal) error
 	Load(name string, u File) error
 	Mask(name string) error
 	Properties(name string) (map[string]interface{}, error)
@@ -42,6 +45,8 @@ type Manager interface {
 	ServiceProperty(name, property string) string
 	State(name string) (*State, error)
 	States(prefix string) (map[string]*State, error)
+	Subscribe(ch chan<- string) error
+	TriggerRestart(name string) error
 	TriggerStart(name string) error
 	TriggerStop(name string) error
 	Unit(name string) string
@@ -116,6 +121,17 @@ func (m *manager) TriggerStart(name string) error {
 	return nil
 }
 
+// TriggerRestart asynchronously restarts the unit identified by the given name.
+// This function does not block for the underlying unit to actually restart.
+func (m *manager) TriggerRestart(name string) error {
+	jobID, err := m.systemd.RestartUnit(name, "replace", nil)
+	if err != nil {
+		return err
+	}
+	log.Infof("triggered unit %q restart: job=%d", name, jobID)
+	return nil
+}
+
 // TriggerStop asynchronously starts the unit identified by the given name.
 // This function does not block for the underlying unit to actually stop.
 func (m *manager) TriggerStop(name string) error {
@@ -126,6 +142,33 @@ func (m *manager) TriggerStop(name string) error {
 	return nil
 }
 
+// Kill sends signal to all processes of the unit identified by the given name.
+func (m *manager) Kill(name string, signal syscall.Signal) error {
+	return m.systemd.KillUnitWithTarget(context.Background(), name, dbus.All, int32(signal))
+}
+
+// Subscribe subscribes to systemd's signals and sends the name of a unit on ch whenever one of its properties, like
+// its ActiveState or SubState, changes.
+func (m *manager) Subscribe(ch chan<- string) error {
+	if err := m.systemd.Subscribe(); err != nil {
+		return err
+	}
+	updates := make(chan *dbus.PropertiesUpdate, 256)
+	errs := make(chan error, 16)
+	m.systemd.SetPropertiesSubscriber(updates, errs)
+	go func() {
+		for {
+			select {
+			case u := <-updates:
+				ch <- u.UnitName
+			case err := <-errs:
+				log.Warnf("dropped unit property update: %s", err)
+			}
+		}
+	}()
+	return nil
+}
+
 // State generates a State object representing the
 // current state of a Unit
 func (m *manager) State(name string) (*State, error) {
@@ -14,6 +14,11 @@
 
 package unit
 
+import (
+	"strings"
+	"syscall"
+)
+
 // mockManager is a manager used for testing.
 type mockManager struct {
 	units map[string]string
@@ -34,13 +39,15 @@ func (t *mockManager) Unload(name string) error {
 	return nil
 }
 
-func (t *mockManager) TriggerStart(name string) error               { return nil }
-func (t *mockManager) TriggerStop(name string) error                { return nil }
-func (t *mockManager) State(name string) (*State, error)            { return &State{}, nil }
-func (t *mockManager) Property(name, property string) string        { return "" }
-func (t *mockManager) ServiceProperty(name, property string) string { return "" }
-func (t *mockManager) Reload() error                                { return nil }
-func (t *mockManager) Mask(name string) error                       { return nil }
+func (t *mockManager) Kill(name string, signal syscall.Signal) error { return nil }
+func (t *mockManager) TriggerRestart(name string) error              { return nil }
+func (t *mockManager) TriggerStart(name string) error                { return nil }
+func (t *mockManager) TriggerStop(name string) error                 { return nil }
+func (t *mockManager) Property(name, property string) string         { return "" }
+func (t *mockManager) ServiceProperty(name, property string) string  { return "" }
+func (t *mockManager) Reload() error                                 { return nil }
+func (t *mockManager) Mask(name string) error                        { return nil }
+func (t *mockManager) Subscribe(ch chan<- string) error              { return nil }
 
 func (t *mockManager) Properties(name string) (map[string]interface{}, error) {
 	return map[string]interface{}{}, nil
@@ -55,8 +62,17 @@ func (t *mockManager) Units() ([]string, error) {
 	return units, nil
 }
 
+func (t *mockManager) State(name string) (*State, error) {
+	return &State{UnitData: t.units[name]}, nil
+}
+
 func (t *mockManager) States(prefix string) (map[string]*State, error) {
 	states := make(map[string]*State)
+	for k, v := range t.units {
+		if strings.HasPrefix(k, prefix) {
+			states[k] = &State{UnitData: v}
+		}
+	}
 	return states, nil
 }
 